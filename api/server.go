@@ -0,0 +1,203 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const (
+	defaultAttendanceLimit = 50
+	maxAttendanceLimit     = 500
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type server struct {
+	dbRepo models.AdminDatabaseInterface
+	token  string
+	mux    *http.ServeMux
+}
+
+func NewServer(dbRepo models.AdminDatabaseInterface, token string) *server {
+	s := &server{
+		dbRepo: dbRepo,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /devices", s.listDevices)
+	s.mux.HandleFunc("GET /devices/{deviceId}", s.getDevice)
+	s.mux.HandleFunc("GET /devices/{deviceId}/attendance", s.listAttendance)
+	s.mux.HandleFunc("GET /devices/{deviceId}/{queue}", s.listSyncItems)
+	s.mux.HandleFunc("DELETE /devices/{deviceId}/{queue}/{studentUnitId}", s.cancelSyncItem)
+	s.mux.HandleFunc("POST /devices/{deviceId}/{queue}/{studentUnitId}", s.requeueSyncItem)
+
+	return s
+}
+
+// Handle mounts an extra handler, such as the metrics endpoint, behind the same auth.
+func (s *server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleThrottledDevices lists the devices that went over their rate limits recently.
+func (s *server) HandleThrottledDevices(source models.ThrottledDevicesInterface) {
+	s.mux.HandleFunc("GET /throttled", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, source.ThrottledDevices())
+	})
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	expected := "Bearer " + s.token
+
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+		writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *server) Start(address string) {
+	go func() {
+		log.Println("admin api listening on", address)
+
+		if err := http.ListenAndServe(address, s); err != nil {
+			log.Fatalln("admin api stopped, Error: ", err.Error())
+		}
+	}()
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, errorResponse{Error: message})
+}
+
+func writeRepositoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, models.ErrConflict):
+		writeError(w, http.StatusConflict, "already queued")
+	default:
+		log.Println("error occurred with database in the admin api, Error: ", err.Error())
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func syncQueue(w http.ResponseWriter, r *http.Request) (string, bool) {
+	queue := r.PathValue("queue")
+
+	if queue != models.SyncQueueInserts && queue != models.SyncQueueDeletes {
+		writeError(w, http.StatusNotFound, "unknown sync queue")
+		return "", false
+	}
+
+	return queue, true
+}
+
+func (s *server) listDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := s.dbRepo.ListDevices()
+
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, devices)
+}
+
+func (s *server) getDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := s.dbRepo.GetDevice(r.PathValue("deviceId"))
+
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, device)
+}
+
+func (s *server) listAttendance(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAttendanceLimit
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+
+		if err != nil || parsed <= 0 || parsed > maxAttendanceLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+
+		limit = parsed
+	}
+
+	punches, err := s.dbRepo.GetRecentPunches(r.PathValue("deviceId"), limit)
+
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, punches)
+}
+
+func (s *server) listSyncItems(w http.ResponseWriter, r *http.Request) {
+	queue, ok := syncQueue(w, r)
+
+	if !ok {
+		return
+	}
+
+	items, err := s.dbRepo.ListSyncItems(r.PathValue("deviceId"), queue)
+
+	if err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, items)
+}
+
+func (s *server) cancelSyncItem(w http.ResponseWriter, r *http.Request) {
+	queue, ok := syncQueue(w, r)
+
+	if !ok {
+		return
+	}
+
+	if err := s.dbRepo.CancelSyncItem(r.PathValue("deviceId"), queue, r.PathValue("studentUnitId")); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) requeueSyncItem(w http.ResponseWriter, r *http.Request) {
+	queue, ok := syncQueue(w, r)
+
+	if !ok {
+		return
+	}
+
+	if err := s.dbRepo.RequeueSyncItem(r.PathValue("deviceId"), queue, r.PathValue("studentUnitId")); err != nil {
+		writeRepositoryError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,51 @@
+package attendance
+
+import (
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type Calendar struct {
+	DayStart time.Duration
+	Shifts   []models.Shift
+}
+
+func NewCalendar(settings *models.DeviceSettings) *Calendar {
+	return &Calendar{
+		DayStart: settings.DayStart,
+		Shifts:   settings.Shifts,
+	}
+}
+
+// BusinessDate returns the attendance date a scan at t belongs to. A scan inside a
+// shift belongs to the day the shift started, otherwise the day begins at DayStart.
+// t must already be in the institution timezone.
+func (c *Calendar) BusinessDate(t time.Time) time.Time {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	timeOfDay := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	for _, shift := range c.Shifts {
+		if shift.Start < shift.End {
+			if timeOfDay >= shift.Start && timeOfDay < shift.End {
+				return midnight
+			}
+			continue
+		}
+
+		//overnight shift, for example 22:00 - 06:00
+		if timeOfDay >= shift.Start {
+			return midnight
+		}
+
+		if timeOfDay < shift.End {
+			return midnight.AddDate(0, 0, -1)
+		}
+	}
+
+	if timeOfDay < c.DayStart {
+		return midnight.AddDate(0, 0, -1)
+	}
+
+	return midnight
+}
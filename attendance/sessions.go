@@ -0,0 +1,168 @@
+package attendance
+
+import (
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type DeviceRules struct {
+	Debounce time.Duration
+	Location *time.Location
+}
+
+type Rules struct {
+	MaxSession time.Duration
+	Devices    map[string]DeviceRules
+}
+
+// BuildSessions pairs the punches of one student, ordered by scan time, into sessions
+// using the same rules the live attendance handler applies: a punch closes the open
+// session when the session started at most MaxSession earlier, unless it falls inside
+// the debounce window of the login. seed is the session of the student that started
+// before the punches and was still open at the first of them, or nil. It is returned
+// first, closed by the punches or open again.
+func BuildSessions(punches []models.Punch, rules *Rules, seed *models.Attendance) []models.Attendance {
+	var sessions []models.Attendance
+
+	open := -1
+
+	if seed != nil {
+		session := *seed
+		session.LogoutAt = nil
+
+		sessions = append(sessions, session)
+		open = 0
+	}
+
+	for _, punch := range punches {
+		device := rules.Devices[punch.UnitId]
+
+		scannedAt := punch.ScannedAt.In(device.Location)
+
+		if open >= 0 && scannedAt.Sub(sessions[open].LoginAt) <= rules.MaxSession {
+			if scannedAt.Sub(sessions[open].LoginAt) < device.Debounce {
+				continue
+			}
+
+			sessions[open].LogoutAt = &scannedAt
+			open = -1
+			continue
+		}
+
+		sessions = append(sessions, models.Attendance{
+			StudentId: punch.StudentId,
+			Date:      punch.BusinessDate,
+			LoginAt:   scannedAt,
+		})
+
+		open = len(sessions) - 1
+	}
+
+	return sessions
+}
+
+// Rebuild derives the attendance sessions of every student with punches between
+// fromDate and toDate and replaces the stored sessions with them. A session that
+// opened before fromDate seeds the pairing of its student, so the punch closing it is
+// not taken for a login. Punches up to maxSession after toDate are read as well, so a
+// session that opens inside the range keeps a logout that falls after it.
+func Rebuild(repo models.AttendanceRebuildInterface, fromDate time.Time, toDate time.Time, maxSession time.Duration, defaultDebounce time.Duration) (int, error) {
+	//the business date of a logout may lie a day after its scan time in utc
+	lookahead := toDate.AddDate(0, 0, int(maxSession/(24*time.Hour))+1)
+
+	punches, err := repo.GetPunches(fromDate, lookahead)
+
+	if err != nil {
+		return 0, err
+	}
+
+	//the business date of a login may lie a day before its scan time in utc
+	openSessions, err := repo.GetSessionsOpenAt(fromDate, fromDate.Add(-maxSession-24*time.Hour))
+
+	if err != nil {
+		return 0, err
+	}
+
+	seeds := make(map[string]models.Attendance, len(openSessions))
+
+	for _, session := range openSessions {
+		//the latest open session of a student is the one the punches continue
+		if seed, ok := seeds[session.StudentId]; ok && seed.LoginAt.After(session.LoginAt) {
+			continue
+		}
+
+		seeds[session.StudentId] = session
+	}
+
+	rules := &Rules{
+		MaxSession: maxSession,
+		Devices:    make(map[string]DeviceRules),
+	}
+
+	for _, punch := range punches {
+		if _, ok := rules.Devices[punch.UnitId]; ok {
+			continue
+		}
+
+		settings, err := repo.GetDeviceSettings(punch.UnitId)
+
+		if err != nil {
+			return 0, err
+		}
+
+		location, err := time.LoadLocation(settings.InstitutionTimezone)
+
+		if err != nil {
+			return 0, err
+		}
+
+		device := DeviceRules{
+			Debounce: defaultDebounce,
+			Location: location,
+		}
+
+		if settings.HasScanDebounce {
+			device.Debounce = settings.ScanDebounce
+		}
+
+		rules.Devices[punch.UnitId] = device
+	}
+
+	var studentIds []string
+	var sessions []models.Attendance
+
+	for start := 0; start < len(punches); {
+		end := start
+
+		for end < len(punches) && punches[end].StudentId == punches[start].StudentId {
+			end++
+		}
+
+		var seed *models.Attendance
+
+		if session, ok := seeds[punches[start].StudentId]; ok {
+			seed = &session
+		}
+
+		//students with punches after toDate only have no sessions to replace
+		if !punches[start].BusinessDate.After(toDate) {
+			studentIds = append(studentIds, punches[start].StudentId)
+		}
+
+		for _, session := range BuildSessions(punches[start:end], rules, seed) {
+			//sessions opened after toDate are outside the rebuild
+			if !session.Date.After(toDate) {
+				sessions = append(sessions, session)
+			}
+		}
+
+		start = end
+	}
+
+	if err := repo.ReplaceAttendance(fromDate, toDate, studentIds, sessions); err != nil {
+		return 0, err
+	}
+
+	return len(sessions), nil
+}
@@ -0,0 +1,170 @@
+package attendance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// rebuildRepository serves the punches of the rebuilt range and the sessions stored
+// before it, and keeps what Rebuild replaces them with.
+type rebuildRepository struct {
+	punches      []models.Punch
+	openSessions []models.Attendance
+	replaced     []models.Attendance
+}
+
+func (r *rebuildRepository) GetDeviceSettings(deviceId string) (*models.DeviceSettings, error) {
+	return &models.DeviceSettings{InstitutionTimezone: "UTC"}, nil
+}
+
+func (r *rebuildRepository) GetPunches(fromDate time.Time, toDate time.Time) ([]models.Punch, error) {
+	var punches []models.Punch
+
+	for _, punch := range r.punches {
+		if !punch.BusinessDate.Before(fromDate) && !punch.BusinessDate.After(toDate) {
+			punches = append(punches, punch)
+		}
+	}
+
+	return punches, nil
+}
+
+func (r *rebuildRepository) GetSessionsOpenAt(fromDate time.Time, since time.Time) ([]models.Attendance, error) {
+	return r.openSessions, nil
+}
+
+func (r *rebuildRepository) ReplaceAttendance(fromDate time.Time, toDate time.Time, studentIds []string, sessions []models.Attendance) error {
+	r.replaced = sessions
+	return nil
+}
+
+func day(d int) time.Time {
+	return time.Date(2024, 6, d, 0, 0, 0, 0, time.UTC)
+}
+
+func punchAt(businessDay int, scannedAt time.Time) models.Punch {
+	return models.Punch{
+		UnitId:       "vs23cg003",
+		StudentId:    "student-7",
+		ScannedAt:    scannedAt,
+		BusinessDate: day(businessDay),
+	}
+}
+
+func TestRebuildSeedsSessionOpenBeforeRange(t *testing.T) {
+	//a night shift logs in on the 3rd and out on the 4th, the rebuild starts on the 4th
+	login := day(3).Add(22 * time.Hour)
+
+	repo := &rebuildRepository{
+		punches: []models.Punch{
+			punchAt(3, login),
+			punchAt(4, day(4).Add(6*time.Hour)),
+			punchAt(4, day(4).Add(9*time.Hour)),
+			punchAt(4, day(4).Add(17*time.Hour)),
+		},
+		openSessions: []models.Attendance{
+			{StudentId: "student-7", Date: day(3), LoginAt: login},
+		},
+	}
+
+	if _, err := Rebuild(repo, day(4), day(4), 16*time.Hour, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		date     time.Time
+		loginAt  time.Time
+		logoutAt time.Time
+	}{
+		{day(3), login, day(4).Add(6 * time.Hour)},
+		{day(4), day(4).Add(9 * time.Hour), day(4).Add(17 * time.Hour)},
+	}
+
+	if len(repo.replaced) != len(want) {
+		t.Fatalf("got %v sessions, want %v: %+v", len(repo.replaced), len(want), repo.replaced)
+	}
+
+	for i, session := range repo.replaced {
+		if !session.Date.Equal(want[i].date) || !session.LoginAt.Equal(want[i].loginAt) || session.LogoutAt == nil || !session.LogoutAt.Equal(want[i].logoutAt) {
+			t.Errorf("session %v: got %v %v-%v, want %v %v-%v", i, session.Date, session.LoginAt, session.LogoutAt, want[i].date, want[i].loginAt, want[i].logoutAt)
+		}
+	}
+}
+
+func TestRebuildWithoutOpenSession(t *testing.T) {
+	repo := &rebuildRepository{
+		punches: []models.Punch{
+			punchAt(4, day(4).Add(9*time.Hour)),
+			punchAt(4, day(4).Add(17*time.Hour)),
+			punchAt(4, day(4).Add(18*time.Hour)),
+		},
+	}
+
+	if _, err := Rebuild(repo, day(4), day(4), 16*time.Hour, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.replaced) != 2 {
+		t.Fatalf("got %v sessions, want 2: %+v", len(repo.replaced), repo.replaced)
+	}
+
+	if repo.replaced[0].LogoutAt == nil || !repo.replaced[0].LogoutAt.Equal(day(4).Add(17*time.Hour)) {
+		t.Errorf("got logout %v, want 17:00", repo.replaced[0].LogoutAt)
+	}
+
+	if repo.replaced[1].LogoutAt != nil {
+		t.Errorf("got logout %v of the last login, want an open session", repo.replaced[1].LogoutAt)
+	}
+}
+
+func TestBuildSessionsReopensSeedPastMaxSession(t *testing.T) {
+	login := day(3).Add(8 * time.Hour)
+	logout := day(4).Add(time.Hour)
+
+	rules := &Rules{
+		MaxSession: 16 * time.Hour,
+		Devices:    map[string]DeviceRules{"vs23cg003": {Debounce: time.Minute, Location: time.UTC}},
+	}
+
+	//the punch of the stored logout is gone, the only punch comes too late to close the seed
+	sessions := BuildSessions([]models.Punch{punchAt(4, day(4).Add(9*time.Hour))}, rules, &models.Attendance{
+		StudentId: "student-7",
+		Date:      day(3),
+		LoginAt:   login,
+		LogoutAt:  &logout,
+	})
+
+	if len(sessions) != 2 || sessions[0].LogoutAt != nil || !sessions[1].LoginAt.Equal(day(4).Add(9*time.Hour)) {
+		t.Errorf("got %+v, want the seed open again and a login at 09:00", sessions)
+	}
+}
+
+func TestRebuildKeepsLogoutAfterRange(t *testing.T) {
+	//a night shift logs in on the 4th and out on the 5th, the rebuild ends on the 4th
+	login := day(4).Add(22 * time.Hour)
+	logout := day(5).Add(6 * time.Hour)
+
+	repo := &rebuildRepository{
+		punches: []models.Punch{
+			punchAt(4, login),
+			punchAt(5, logout),
+			punchAt(5, day(5).Add(9*time.Hour)),
+		},
+	}
+
+	if _, err := Rebuild(repo, day(4), day(4), 16*time.Hour, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(repo.replaced) != 1 {
+		t.Fatalf("got %v sessions, want only the session of the 4th: %+v", len(repo.replaced), repo.replaced)
+	}
+
+	session := repo.replaced[0]
+
+	if !session.LoginAt.Equal(login) || session.LogoutAt == nil || !session.LogoutAt.Equal(logout) {
+		t.Errorf("got %v-%v, want %v-%v", session.LoginAt, session.LogoutAt, login, logout)
+	}
+}
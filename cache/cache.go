@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+)
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// Cache is a size bounded least recently used cache whose entries expire after a
+// ttl. Hits and misses are counted under the name of the cache.
+type Cache[K comparable, V any] struct {
+	name       string
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[K]*list.Element
+	order      *list.List
+	generation uint64
+}
+
+func New[K comparable, V any](name string, ttl time.Duration, maxEntries int) *Cache[K, V] {
+	c := &Cache[K, V]{
+		name:       name,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[K]*list.Element),
+		order:      list.New(),
+	}
+
+	metrics.PublishCache(name+"_cache", func() metrics.CacheStats {
+		return metrics.CacheStats{
+			Entries:    c.Len(),
+			MaxEntries: maxEntries,
+		}
+	})
+
+	return c
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry[K, V])
+
+		if time.Now().Before(e.expiresAt) {
+			c.order.MoveToFront(element)
+			metrics.CacheHits.Add(c.name, 1)
+			return e.value, true
+		}
+
+		c.remove(element)
+	}
+
+	metrics.CacheMisses.Add(c.name, 1)
+
+	var zero V
+	return zero, false
+}
+
+// Load returns the cached value of key or loads and caches it. A value loaded while
+// the cache was invalidated is returned but not cached, it may already be stale.
+func (c *Cache[K, V]) Load(key K, load func() (V, error)) (V, error) {
+	return c.LoadMatching(key, load, nil)
+}
+
+// LoadMatching is Load for a cache that only keeps the values keep accepts, the
+// other loaded values are returned without being cached. A nil keep accepts all.
+func (c *Cache[K, V]) LoadMatching(key K, load func() (V, error), keep func(V) bool) (V, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	value, err := load()
+
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation == c.generation && (keep == nil || keep(value)) {
+		c.set(key, value)
+	}
+
+	return value, nil
+}
+
+// Set caches value under key, a value being loaded at the same time is not cached
+// over it.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	c.set(key, value)
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Purge empties the cache, for when invalidations may have been missed.
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	c.entries = make(map[K]*list.Element)
+	c.order.Init()
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *Cache[K, V]) set(key K, value V) {
+	expiresAt := time.Now().Add(c.ttl)
+
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry[K, V])
+		e.value = value
+		e.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&entry[K, V]{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		metrics.CacheEvictions.Add(c.name, 1)
+	}
+}
+
+func (c *Cache[K, V]) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry[K, V]).key)
+}
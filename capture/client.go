@@ -0,0 +1,99 @@
+package capture
+
+import (
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// RecordingClient records every Publish of the processor before passing it on.
+type RecordingClient struct {
+	mqtt.Client
+	recorder *Recorder
+}
+
+func NewRecordingClient(client mqtt.Client, recorder *Recorder) *RecordingClient {
+	return &RecordingClient{
+		Client:   client,
+		recorder: recorder,
+	}
+}
+
+func (c *RecordingClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.recorder.Outgoing(topic, qos, retained, payload)
+	return c.Client.Publish(topic, qos, retained, payload)
+}
+
+// Message is an incoming message rebuilt from a record.
+type Message struct {
+	record *Record
+}
+
+func NewMessage(record *Record) *Message {
+	return &Message{
+		record: record,
+	}
+}
+
+func (m *Message) Duplicate() bool   { return false }
+func (m *Message) Qos() byte         { return m.record.Qos }
+func (m *Message) Retained() bool    { return m.record.Retained }
+func (m *Message) Topic() string     { return m.record.Topic }
+func (m *Message) MessageID() uint16 { return 0 }
+func (m *Message) Payload() []byte   { return m.record.Payload }
+func (m *Message) Ack()              {}
+
+// ReplayClient stands in for the broker during a replay and keeps everything the
+// processor publishes, redacted like the capture it is compared with.
+type ReplayClient struct {
+	mu        sync.Mutex
+	published []Record
+}
+
+func NewReplayClient() *ReplayClient {
+	return &ReplayClient{}
+}
+
+func (c *ReplayClient) Published() []Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Record(nil), c.published...)
+}
+
+func (c *ReplayClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	c.published = append(c.published, Record{
+		Direction: DirectionOutgoing,
+		Topic:     topic,
+		Qos:       qos,
+		Retained:  retained,
+		Payload:   append([]byte(nil), Redact(payloadBytes(payload))...),
+	})
+	c.mu.Unlock()
+
+	return &mqtt.DummyToken{}
+}
+
+func (c *ReplayClient) IsConnected() bool      { return true }
+func (c *ReplayClient) IsConnectionOpen() bool { return true }
+func (c *ReplayClient) Connect() mqtt.Token    { return &mqtt.DummyToken{} }
+func (c *ReplayClient) Disconnect(uint)        {}
+
+func (c *ReplayClient) Subscribe(string, byte, mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *ReplayClient) SubscribeMultiple(map[string]byte, mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *ReplayClient) Unsubscribe(...string) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *ReplayClient) AddRoute(string, mqtt.MessageHandler) {}
+
+func (c *ReplayClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewOptionsReader(mqtt.NewClientOptions())
+}
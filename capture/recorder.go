@@ -0,0 +1,198 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	DirectionIncoming = "in"
+	DirectionOutgoing = "out"
+)
+
+// Record is one line of a capture file. Payloads are kept as bytes, which
+// encoding/json writes as base64, so CBOR payloads survive the capture as well.
+// Fingerprint templates in outgoing payloads are redacted before they are written.
+type Record struct {
+	Direction string    `json:"dir"`
+	Topic     string    `json:"topic"`
+	Qos       byte      `json:"qos"`
+	Retained  bool      `json:"retained,omitempty"`
+	Payload   []byte    `json:"payload"`
+	Time      time.Time `json:"time"`
+}
+
+// Recorder appends records to a JSONL file and rotates it once it reaches maxBytes,
+// keeping maxFiles rotated files as path.1 (newest) to path.<maxFiles>.
+type Recorder struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func NewRecorder(path string, maxBytes int64, maxFiles int) (*Recorder, error) {
+	r := &Recorder{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	for i := r.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%v.%v", r.path, i), fmt.Sprintf("%v.%v", r.path, i+1))
+	}
+
+	if r.maxFiles > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *Recorder) Write(record *Record) error {
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(line)
+
+	r.size += int64(n)
+
+	return err
+}
+
+func (r *Recorder) Incoming(message mqtt.Message) error {
+	return r.Write(&Record{
+		Direction: DirectionIncoming,
+		Topic:     message.Topic(),
+		Qos:       message.Qos(),
+		Retained:  message.Retained(),
+		Payload:   message.Payload(),
+		Time:      time.Now().UTC(),
+	})
+}
+
+func (r *Recorder) Outgoing(topic string, qos byte, retained bool, payload interface{}) error {
+	return r.Write(&Record{
+		Direction: DirectionOutgoing,
+		Topic:     topic,
+		Qos:       qos,
+		Retained:  retained,
+		Payload:   Redact(payloadBytes(payload)),
+		Time:      time.Now().UTC(),
+	})
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// payloadBytes accepts the payload types paho accepts for Publish.
+func payloadBytes(payload interface{}) []byte {
+	switch p := payload.(type) {
+	case []byte:
+		return p
+	case string:
+		return []byte(p)
+	case bytes.Buffer:
+		return p.Bytes()
+	case *bytes.Buffer:
+		return p.Bytes()
+	default:
+		return nil
+	}
+}
+
+// ReadFile reads the records of one capture file, rotated files can be read one
+// after another from the oldest.
+func ReadFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var records []Record
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record Record
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid capture record on line %v: %w", line, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,47 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+)
+
+// templateFields carry fingerprint templates, whole in the fpd of insertsync responses
+// and in parts in the dat of insert sync chunks.
+var templateFields = []string{"fpd", "dat"}
+
+// Redact replaces the fingerprint templates of an outgoing payload with a digest, so
+// capture files hold no templates while a replay still notices a changed template.
+// A payload that can not be re-encoded is dropped rather than written as is.
+func Redact(payload []byte) []byte {
+	c := codec.Detect(payload)
+
+	var value map[string]any
+
+	if err := c.Unmarshal(payload, &value); err != nil {
+		return payload
+	}
+
+	redacted := false
+
+	for _, field := range templateFields {
+		if text, ok := value[field].(string); ok && text != "" {
+			digest := sha256.Sum256([]byte(text))
+			value[field] = "redacted:" + hex.EncodeToString(digest[:8])
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return payload
+	}
+
+	encoded, err := c.Marshal(value)
+
+	if err != nil {
+		return nil
+	}
+
+	return encoded
+}
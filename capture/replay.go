@@ -0,0 +1,186 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+)
+
+const (
+	DifferenceChanged = "changed"
+	DifferenceMissing = "missing"
+	DifferenceExtra   = "extra"
+)
+
+type Difference struct {
+	Kind     string `json:"kind"`
+	Topic    string `json:"topic"`
+	Index    int    `json:"index"`
+	Recorded string `json:"recorded,omitempty"`
+	Replayed string `json:"replayed,omitempty"`
+}
+
+// Replay feeds the incoming records to process one at a time. With a speed above
+// zero the gaps between the records are kept, divided by speed, a speed of zero
+// replays as fast as possible.
+func Replay(records []Record, process func(mqtt.Message), speed float64) int {
+	var previous time.Time
+	replayed := 0
+
+	for i := range records {
+		record := &records[i]
+
+		if record.Direction != DirectionIncoming {
+			continue
+		}
+
+		if speed > 0 && !previous.IsZero() {
+			if gap := record.Time.Sub(previous); gap > 0 {
+				time.Sleep(time.Duration(float64(gap) / speed))
+			}
+		}
+
+		previous = record.Time
+
+		process(NewMessage(record))
+
+		replayed++
+	}
+
+	return replayed
+}
+
+// Diff compares the responses of a replay with the recorded ones, topic by topic
+// in publish order, so that responses to different devices may interleave freely.
+// Payloads are compared after decoding, which ignores the order of keys.
+func Diff(recorded []Record, replayed []Record, ignore func(*Record) bool) []Difference {
+	expected := groupByTopic(recorded, ignore)
+	actual := groupByTopic(replayed, ignore)
+
+	var differences []Difference
+
+	for _, topic := range topicsOf(recorded, replayed) {
+		want := expected[topic]
+		got := actual[topic]
+
+		for i := 0; i < max(len(want), len(got)); i++ {
+			switch {
+			case i >= len(got):
+				differences = append(differences, Difference{Kind: DifferenceMissing, Topic: topic, Index: i, Recorded: display(want[i].Payload)})
+			case i >= len(want):
+				differences = append(differences, Difference{Kind: DifferenceExtra, Topic: topic, Index: i, Replayed: display(got[i].Payload)})
+			case !samePayload(want[i].Payload, got[i].Payload):
+				differences = append(differences, Difference{Kind: DifferenceChanged, Topic: topic, Index: i, Recorded: display(want[i].Payload), Replayed: display(got[i].Payload)})
+			}
+		}
+	}
+
+	return differences
+}
+
+func groupByTopic(records []Record, ignore func(*Record) bool) map[string][]*Record {
+	groups := make(map[string][]*Record)
+
+	for i := range records {
+		record := &records[i]
+
+		if record.Direction != DirectionOutgoing || (ignore != nil && ignore(record)) {
+			continue
+		}
+
+		groups[record.Topic] = append(groups[record.Topic], record)
+	}
+
+	return groups
+}
+
+// topicsOf lists the topics in the order they first appear.
+func topicsOf(recordSets ...[]Record) []string {
+	seen := make(map[string]bool)
+
+	var topics []string
+
+	for _, records := range recordSets {
+		for _, record := range records {
+			if record.Direction == DirectionOutgoing && !seen[record.Topic] {
+				seen[record.Topic] = true
+				topics = append(topics, record.Topic)
+			}
+		}
+	}
+
+	return topics
+}
+
+func decodePayload(payload []byte) (any, bool) {
+	var value any
+
+	if err := codec.Detect(payload).Unmarshal(payload, &value); err != nil {
+		return nil, false
+	}
+
+	return normalize(value), true
+}
+
+// normalize turns the integers of a CBOR payload into float64, as encoding/json
+// decodes numbers, so that the same message compares equal in both encodings.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case uint64:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case []any:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = normalize(v[key])
+		}
+	}
+	return value
+}
+
+func samePayload(a []byte, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+
+	decodedA, okA := decodePayload(a)
+	decodedB, okB := decodePayload(b)
+
+	return okA && okB && reflect.DeepEqual(decodedA, decodedB)
+}
+
+// MessageType returns the mty field of a payload, or false for payloads without one.
+func MessageType(payload []byte) (uint8, bool) {
+	header := struct {
+		MessageType *uint8 `json:"mty"`
+	}{}
+
+	if err := codec.Detect(payload).Unmarshal(payload, &header); err != nil || header.MessageType == nil {
+		return 0, false
+	}
+
+	return *header.MessageType, true
+}
+
+func display(payload []byte) string {
+	if codec.Detect(payload) == codec.Json {
+		return string(payload)
+	}
+
+	if value, ok := decodePayload(payload); ok {
+		if text, err := json.Marshal(value); err == nil {
+			return "cbor " + string(text)
+		}
+	}
+
+	return "hex " + hex.EncodeToString(payload)
+}
@@ -5,23 +5,175 @@ import (
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/api"
+	"github.com/vithsutra/biometric-project-message-processor/capture"
+	"github.com/vithsutra/biometric-project-message-processor/config"
+	"github.com/vithsutra/biometric-project-message-processor/encryption"
+	"github.com/vithsutra/biometric-project-message-processor/fingerprint"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
 	"github.com/vithsutra/biometric-project-message-processor/processor"
+	"github.com/vithsutra/biometric-project-message-processor/ratelimit"
 	"github.com/vithsutra/biometric-project-message-processor/repository"
+	"github.com/vithsutra/biometric-project-message-processor/sink"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+	"github.com/vithsutra/biometric-project-message-processor/webhook"
 )
 
-func Start(db *database, mqttConn *mqttConn) {
+func newTemplateEnvelope(config *config.Variables) *encryption.Envelope {
+	keys, err := encryption.NewKeyProviderFromConfig(config.TemplateKeyFile, config.TemplateKeys, config.TemplateCurrentKey)
 
-	dbRepo := repository.NewPostgresRepository(db.conn)
+	if err != nil {
+		log.Fatalln("invalid fingerprint encryption keys, Error: ", err.Error())
+	}
+
+	if keys == nil {
+		return nil
+	}
+
+	return encryption.NewEnvelope(keys)
+}
+
+// newEventSink and newTemplateValidator build the parts of the processor that the
+// service and replays both take from the processing config.
+func newEventSink(processing *config.Processing) models.EventSink {
+	eventSink, err := sink.New(processing.EventSink, processing.EventSinkTarget, processing.EventSinkTopic, processing.EventQueueSize)
+
+	if err != nil {
+		log.Fatalln("failed to create the event sink, Error: ", err.Error())
+	}
+
+	return eventSink
+}
+
+func newTemplateValidator(processing *config.Processing) *fingerprint.Validator {
+	templateValidator, err := fingerprint.NewValidator(
+		processing.TemplateEncoding,
+		processing.TemplateLength,
+		processing.TemplateHeader,
+		processing.TemplateChecksum,
+	)
+
+	if err != nil {
+		log.Fatalln("invalid fingerprint template validation settings, Error: ", err.Error())
+	}
+
+	return templateValidator
+}
+
+// Start runs the service until stop is closed, then it disconnects from the broker
+// and flushes the queued events and the capture file.
+func Start(db *database, mqttConn *mqttConn, config *config.Variables, stop <-chan struct{}) {
+
+	dbRepo := repository.NewPostgresRepository(db.conn, newTemplateEnvelope(config))
+
+	requestTopic, err := topic.NewTemplate(config.MqttRequestTopic)
+
+	if err != nil {
+		log.Fatalln("invalid MQTT_REQUEST_TOPIC, Error: ", err.Error())
+	}
+
+	if !requestTopic.HasType() {
+		log.Fatalln("invalid MQTT_REQUEST_TOPIC, Error: missing {type} placeholder")
+	}
+
+	responseTopic, err := topic.NewTemplate(config.MqttResponseTopic)
+
+	if err != nil {
+		log.Fatalln("invalid MQTT_RESPONSE_TOPIC, Error: ", err.Error())
+	}
+
+	eventSink := newEventSink(&config.Processing)
+
+	templateValidator := newTemplateValidator(&config.Processing)
+
+	var mqttClient mqtt.Client = mqttConn.client
+
+	var recorder *capture.Recorder
+
+	if config.CaptureFile != "" {
+		recorder, err = capture.NewRecorder(config.CaptureFile, config.CaptureMaxBytes, config.CaptureMaxFiles)
+
+		if err != nil {
+			log.Fatalln("failed to open the capture file, Error: ", err.Error())
+		}
+
+		mqttClient = capture.NewRecordingClient(mqttClient, recorder)
+
+		log.Println("recording mqtt traffic to", config.CaptureFile)
+	}
+
+	var deviceRepo models.DeviceDatabseInterface = dbRepo
+
+	//workers wait for their batch to commit, a batch can only fill up with as many workers
+	var workerNodesCount uint32 = 20
+
+	if config.AttendanceWriteBehindMode == "on" {
+		deviceRepo = repository.NewWriteBehindRepository(dbRepo, dbRepo, config.AttendanceFlushInterval, config.AttendanceBatchSize)
+		workerNodesCount = max(workerNodesCount, uint32(config.AttendanceBatchSize))
+
+		log.Println("buffering attendance writes for", config.AttendanceFlushInterval)
+	}
+
+	//a threshold of zero turns the circuit breaker off
+	if config.DatabaseBreakerFailures > 0 {
+		breaker := repository.NewCircuitBreaker(config.DatabaseBreakerFailures, config.DatabaseBreakerCooldown)
+		deviceRepo = repository.NewCircuitBreakerRepository(deviceRepo, breaker)
+	}
+
+	//the cache sits in front of the breaker so that cached devices keep working while the database is down
+	if config.DeviceCacheMode != "off" {
+		cachedRepo := repository.NewCachedRepository(deviceRepo, config.DeviceCacheTtl, config.DeviceCacheMaxEntries)
+		cachedRepo.Listen(dbRepo)
+		deviceRepo = cachedRepo
+	}
+
+	limiter := ratelimit.NewLimiter(config.RateLimits)
+
+	if limiter != nil {
+		log.Println("rate limiting device messages")
+	}
 
 	messageProcessor := processor.NewMessageProcessor(
-		mqttConn.client,
-		dbRepo,
-		20,
+		mqttClient,
+		deviceRepo,
+		requestTopic,
+		responseTopic,
+		config.AttendanceDebounce,
+		config.AttendanceSession,
+		eventSink,
+		config.SyncNotifyMode != processor.SyncNotifyOff,
+		templateValidator,
+		config.MaxChunkSize,
+		config.DeprecatedProtocolVersions,
+		limiter,
+		config.DeadLetterTopic,
+		workerNodesCount,
 		500,
 	)
 
 	messageProcessor.Start()
 
+	syncNotifier := processor.NewSyncNotifier(messageProcessor, dbRepo, config.SyncNotifyMode, config.SyncNotifyInterval)
+
+	syncNotifier.Start()
+
+	webhookDispatcher := webhook.NewDispatcher(dbRepo, config.WebhookTimeout, config.WebhookMaxAttempts, config.WebhookRetention)
+
+	webhookDispatcher.Start()
+
+	if config.AdminHttpAddress != "" {
+		adminServer := api.NewServer(dbRepo, config.AdminApiToken)
+
+		adminServer.Handle("GET /metrics", metrics.Handler())
+
+		if limiter != nil {
+			adminServer.HandleThrottledDevices(limiter)
+		}
+
+		adminServer.Start(config.AdminHttpAddress)
+	}
+
 	for {
 		if status := mqttConn.client.IsConnected(); !status {
 			if token := mqttConn.client.Connect(); token.Wait() && token.Error() != nil {
@@ -29,14 +181,42 @@ func Start(db *database, mqttConn *mqttConn) {
 			}
 
 			if mqttConn.client.IsConnected() {
-				//for device publish topic -> vs242s001/connection/message
-				//device_id/process/message_type/message
-				mqttConn.client.Subscribe("+/process/+/message", 1, func(c mqtt.Client, m mqtt.Message) {
+				//for device publish topic -> vs242s001/process/connection/message
+				//default template -> {device}/process/{type}/message
+				mqttConn.client.Subscribe(messageProcessor.Subscription(), 1, func(c mqtt.Client, m mqtt.Message) {
+					if recorder != nil {
+						if err := recorder.Incoming(m); err != nil {
+							log.Println("failed to record the incoming message, Error: ", err.Error())
+						}
+					}
+
 					messageProcessor.Push(m)
 				})
+
+				go syncNotifier.NotifyOnlineDevices()
 			}
 		}
 
-		time.Sleep(time.Second * 1)
+		select {
+		case <-stop:
+			shutdown(mqttConn, eventSink, recorder)
+			return
+		case <-time.After(time.Second * 1):
+		}
+	}
+}
+
+// shutdown stops taking messages before the events and captures they produced are flushed.
+func shutdown(mqttConn *mqttConn, eventSink models.EventSink, recorder *capture.Recorder) {
+	mqttConn.client.Disconnect(250)
+
+	if err := eventSink.Close(); err != nil {
+		log.Println("failed to close the event sink, Error: ", err.Error())
+	}
+
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			log.Println("failed to close the capture file, Error: ", err.Error())
+		}
 	}
 }
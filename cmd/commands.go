@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/attendance"
+	"github.com/vithsutra/biometric-project-message-processor/capture"
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+	"github.com/vithsutra/biometric-project-message-processor/config"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/processor"
+	"github.com/vithsutra/biometric-project-message-processor/repository"
+	"github.com/vithsutra/biometric-project-message-processor/simulator"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+func runCommand(name string, args []string) {
+	switch name {
+	case "migrate":
+		runMigrate()
+	case "rebuild-attendance":
+		runRebuildAttendance(args)
+	case "reencrypt-templates":
+		runReencryptTemplates(args)
+	case "simulate":
+		runSimulate(args)
+	case "load-test":
+		runLoadTest(args)
+	case "replay":
+		runReplay(args)
+	default:
+		log.Fatalln("unknown command: ", name)
+	}
+}
+
+func runMigrate() {
+	config := config.InitConfig()
+
+	db := NewDatabase(config.DatabaseUrl)
+
+	db.CheckDatabaseConnection()
+
+	defer db.CloseConnection()
+
+	if err := repository.Migrate(db.conn); err != nil {
+		log.Fatalln("failed to apply the database migrations, Error: ", err.Error())
+	}
+
+	log.Println("database migrations applied")
+}
+
+func runRebuildAttendance(args []string) {
+	flags := flag.NewFlagSet("rebuild-attendance", flag.ExitOnError)
+
+	today := time.Now().Format("2006-01-02")
+
+	fromDate := flags.String("from", today, "first attendance date to rebuild (YYYY-MM-DD)")
+	toDate := flags.String("to", today, "last attendance date to rebuild (YYYY-MM-DD)")
+
+	flags.Parse(args)
+
+	from, err := time.Parse("2006-01-02", *fromDate)
+
+	if err != nil {
+		log.Fatalln("invalid -from date: ", *fromDate)
+	}
+
+	to, err := time.Parse("2006-01-02", *toDate)
+
+	if err != nil {
+		log.Fatalln("invalid -to date: ", *toDate)
+	}
+
+	config := config.InitConfig()
+
+	db := NewDatabase(config.DatabaseUrl)
+
+	db.CheckDatabaseConnection()
+
+	defer db.CloseConnection()
+
+	dbRepo := repository.NewPostgresRepository(db.conn, newTemplateEnvelope(config))
+
+	sessions, err := attendance.Rebuild(dbRepo, from, to, config.AttendanceSession, config.AttendanceDebounce)
+
+	if err != nil {
+		log.Fatalln("failed to rebuild the attendance, Error: ", err.Error())
+	}
+
+	log.Println("rebuilt", sessions, "attendance sessions from", *fromDate, "to", *toDate)
+}
+
+// runReencryptTemplates encrypts plaintext templates and rotates templates to the
+// current key. With -interval it keeps running and picks up newly enrolled templates.
+func runReencryptTemplates(args []string) {
+	flags := flag.NewFlagSet("reencrypt-templates", flag.ExitOnError)
+
+	interval := flags.Duration("interval", 0, "repeat the pass at this interval instead of exiting (e.g. 5m)")
+
+	flags.Parse(args)
+
+	config := config.InitConfig()
+
+	templateEnvelope := newTemplateEnvelope(config)
+
+	if templateEnvelope == nil {
+		log.Fatalln("please set FINGERPRINT_KEY_FILE or FINGERPRINT_KEYS to re-encrypt the fingerprint templates")
+	}
+
+	db := NewDatabase(config.DatabaseUrl)
+
+	db.CheckDatabaseConnection()
+
+	defer db.CloseConnection()
+
+	dbRepo := repository.NewPostgresRepository(db.conn, templateEnvelope)
+
+	for {
+		for _, table := range []string{"fingerprintdata", "inserts"} {
+			rotated, failed, err := dbRepo.ReencryptTemplates(table)
+
+			if err != nil {
+				log.Println("failed to re-encrypt the fingerprint templates of ", table, ", Error: ", err.Error())
+			}
+
+			log.Println("re-encrypted", rotated, "fingerprint templates in", table, "with key", templateEnvelope.CurrentKeyId(), "failed:", failed)
+		}
+
+		if *interval <= 0 {
+			return
+		}
+
+		time.Sleep(*interval)
+	}
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// simulatorFlags registers the connection and protocol flags shared by the
+// commands that drive virtual devices against a broker.
+func simulatorFlags(flags *flag.FlagSet) func() *simulator.Options {
+	broker := flags.String("broker", fmt.Sprintf("tcp://%v:%v", envOrDefault("MQTT_BROKER_HOST", "localhost"), envOrDefault("MQTT_BROKER_PORT", "1883")), "mqtt broker url")
+	userName := flags.String("username", os.Getenv("MQTT_BROKER_USERNAME"), "mqtt user name")
+	password := flags.String("password", os.Getenv("MQTT_BROKER_PASSWORD"), "mqtt password")
+	requestTopic := flags.String("request-topic", envOrDefault("MQTT_REQUEST_TOPIC", config.DefaultMqttRequestTopic), "request topic template")
+	responseTopic := flags.String("response-topic", envOrDefault("MQTT_RESPONSE_TOPIC", config.DefaultMqttResponseTopic), "response topic template")
+	tenant := flags.String("tenant", "", "value of the {tenant} topic placeholder")
+	site := flags.String("site", "", "value of the {site} topic placeholder")
+	encoding := flags.String("encoding", codec.NameJson, "payload encoding, json or cbor")
+	version := flags.Uint("protocol-version", 0, "protocol version sent in the connection request, 0 for none")
+	timezone := flags.String("timezone", "Local", "timezone of the device clock")
+	timeout := flags.Duration("timeout", 5*time.Second, "response timeout")
+
+	return func() *simulator.Options {
+		requestTemplate, err := topic.NewTemplate(*requestTopic)
+
+		if err != nil {
+			log.Fatalln("invalid -request-topic, Error: ", err.Error())
+		}
+
+		responseTemplate, err := topic.NewTemplate(*responseTopic)
+
+		if err != nil {
+			log.Fatalln("invalid -response-topic, Error: ", err.Error())
+		}
+
+		if err := requestTemplate.Fills(responseTemplate); err != nil {
+			log.Fatalln("invalid -response-topic, Error: ", err.Error())
+		}
+
+		payloadCodec, ok := codec.ByName(*encoding)
+
+		if !ok {
+			log.Fatalln("invalid -encoding: ", *encoding)
+		}
+
+		if *version != 0 && !models.IsSupportedProtocolVersion(uint8(*version)) {
+			log.Fatalln("unsupported -protocol-version: ", *version)
+		}
+
+		location, err := time.LoadLocation(*timezone)
+
+		if err != nil {
+			log.Fatalln("invalid -timezone, Error: ", err.Error())
+		}
+
+		return &simulator.Options{
+			Broker:          *broker,
+			UserName:        *userName,
+			Password:        *password,
+			RequestTopic:    requestTemplate,
+			ResponseTopic:   responseTemplate,
+			Tenant:          *tenant,
+			Site:            *site,
+			Codec:           payloadCodec,
+			ProtocolVersion: uint8(*version),
+			Location:        location,
+			ResponseTimeout: *timeout,
+		}
+	}
+}
+
+// simulatedDevices returns the -devices list, or -count ids built from -prefix.
+// The devices must be registered in the biometric table to be accepted.
+func simulatedDevices(devices string, prefix string, count int) []string {
+	if ids := splitList(devices); len(ids) > 0 {
+		return ids
+	}
+
+	ids := make([]string, count)
+
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%v%03d", prefix, i+1)
+	}
+
+	return ids
+}
+
+func parseStudents(value string) []uint16 {
+	var students []uint16
+
+	for _, item := range splitList(value) {
+		studentId, err := strconv.ParseUint(item, 10, 16)
+
+		if err != nil {
+			log.Fatalln("invalid student unit id: ", item)
+		}
+
+		students = append(students, uint16(studentId))
+	}
+
+	return students
+}
+
+func runSimulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+
+	options := simulatorFlags(flags)
+
+	devices := flags.String("devices", "", "comma separated device ids")
+	prefix := flags.String("prefix", "sim", "device id prefix used with -count")
+	count := flags.Int("count", 1, "number of virtual devices when -devices is empty")
+	students := flags.String("students", "", "comma separated student unit ids enrolled on the devices")
+	scans := flags.Int("scans", 10, "attendance scans per device")
+	scanInterval := flags.Duration("scan-interval", time.Second, "pause between the scans of a device")
+	chunkSize := flags.Uint("chunk-size", 0, "request chunked insert sync with this chunk size, 0 for single message")
+
+	flags.Parse(args)
+
+	if *chunkSize > 0xFFFF {
+		log.Fatalln("invalid -chunk-size: ", *chunkSize)
+	}
+
+	scenario := &simulator.Scenario{
+		Students:     parseStudents(*students),
+		Scans:        *scans,
+		ScanInterval: *scanInterval,
+		ChunkSize:    uint16(*chunkSize),
+	}
+
+	report := simulator.Run(options(), simulatedDevices(*devices, *prefix, *count), scenario)
+
+	report.WriteText(os.Stdout)
+
+	if report.Failures() > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseRates parses "attendance=50,insertsync=5" into requests per second by type.
+func parseRates(value string) map[string]float64 {
+	rates := make(map[string]float64)
+
+	for _, item := range splitList(value) {
+		messageType, rateValue, ok := strings.Cut(item, "=")
+
+		if !ok || !slices.Contains(simulator.LoadTypes, messageType) {
+			log.Fatalln("invalid -rates entry, expected <type>=<requests per second> with type one of ", strings.Join(simulator.LoadTypes, ", "), ": ", item)
+		}
+
+		rate, err := strconv.ParseFloat(rateValue, 64)
+
+		if err != nil || rate <= 0 {
+			log.Fatalln("invalid rate in -rates entry: ", item)
+		}
+
+		rates[messageType] = rate
+	}
+
+	return rates
+}
+
+func runLoadTest(args []string) {
+	flags := flag.NewFlagSet("load-test", flag.ExitOnError)
+
+	options := simulatorFlags(flags)
+
+	devices := flags.String("devices", "", "comma separated device ids")
+	prefix := flags.String("prefix", "sim", "device id prefix used with -count")
+	count := flags.Int("count", 10, "number of virtual devices when -devices is empty")
+	students := flags.String("students", "", "comma separated student unit ids enrolled on the devices")
+	rates := flags.String("rates", "attendance=10", "target requests per second by type, e.g. attendance=50,insertsync=5")
+	ramp := flags.Duration("ramp", 30*time.Second, "time to ramp the rates up from zero")
+	duration := flags.Duration("duration", time.Minute, "time to hold the target rates after the ramp")
+	metricsUrl := flags.String("metrics-url", "", "admin api metrics url to sample the queue saturation and database round trips, e.g. http://localhost:8080/metrics")
+	adminToken := flags.String("admin-token", os.Getenv("ADMIN_API_TOKEN"), "admin api token for -metrics-url")
+	format := flags.String("format", "text", "report format, text or json")
+	output := flags.String("output", "", "write the report to this file instead of stdout")
+
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		log.Fatalln("invalid -format: ", *format)
+	}
+
+	profile := &simulator.LoadProfile{
+		Rates:    parseRates(*rates),
+		Ramp:     *ramp,
+		Duration: *duration,
+		Students: parseStudents(*students),
+	}
+
+	var sampler simulator.MetricsSampler
+
+	if *metricsUrl != "" {
+		sampler = simulator.NewMetricsSampler(*metricsUrl, *adminToken)
+	}
+
+	report := simulator.Load(options(), simulatedDevices(*devices, *prefix, *count), profile, sampler)
+
+	out := os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+
+		if err != nil {
+			log.Fatalln("failed to create the report file, Error: ", err.Error())
+		}
+
+		defer file.Close()
+
+		out = file
+	}
+
+	if *format == "json" {
+		if err := report.WriteJson(out); err != nil {
+			log.Fatalln("failed to write the report, Error: ", err.Error())
+		}
+		return
+	}
+
+	report.WriteText(out)
+}
+
+// runReplay feeds a capture through the message processor and diffs the responses
+// with the recorded ones. Replays against postgres change the database like the
+// original traffic did, the memory repository starts from -fixture instead. Events
+// go to the configured EVENT_SINK, set EVENT_SINK=none to keep them out of production.
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+
+	//the defaults come from the service configuration, so that a replay answers like production
+	processing := config.InitProcessingConfig()
+
+	captureFiles := flags.String("capture", "", "comma separated capture files, oldest first")
+	repositoryKind := flags.String("repository", "memory", "repository to replay against, memory or postgres")
+	fixture := flags.String("fixture", "", "json fixture for the memory repository")
+	databaseUrl := flags.String("database-url", os.Getenv("DATABASE_URL"), "database url for the postgres repository")
+	speed := flags.Float64("speed", 0, "replay speed, 1 keeps the recorded gaps, 0 replays as fast as possible")
+	ignoreTypes := flags.String("ignore-types", "7", "comma separated response message types left out of the diff")
+	requestTopic := flags.String("request-topic", processing.MqttRequestTopic, "request topic template")
+	responseTopic := flags.String("response-topic", processing.MqttResponseTopic, "response topic template")
+	debounce := flags.Duration("debounce", processing.AttendanceDebounce, "attendance debounce")
+	maxSession := flags.Duration("max-session", processing.AttendanceSession, "maximum attendance session length")
+	maxChunkSize := flags.Uint("max-chunk-size", uint(processing.MaxChunkSize), "maximum insert sync chunk size")
+	format := flags.String("format", "text", "report format, text or json")
+
+	flags.Parse(args)
+
+	if *captureFiles == "" {
+		log.Fatalln("please set -capture")
+	}
+
+	var records []capture.Record
+
+	for _, path := range splitList(*captureFiles) {
+		fileRecords, err := capture.ReadFile(path)
+
+		if err != nil {
+			log.Fatalln("failed to read the capture ", path, ", Error: ", err.Error())
+		}
+
+		records = append(records, fileRecords...)
+	}
+
+	ignored := make(map[uint8]bool)
+
+	for _, item := range splitList(*ignoreTypes) {
+		messageType, err := strconv.ParseUint(item, 10, 8)
+
+		if err != nil {
+			log.Fatalln("invalid -ignore-types entry: ", item)
+		}
+
+		ignored[uint8(messageType)] = true
+	}
+
+	var dbRepo models.DeviceDatabseInterface
+
+	switch *repositoryKind {
+	case "memory":
+		var seed *repository.MemoryFixture
+
+		if *fixture != "" {
+			var err error
+
+			if seed, err = repository.LoadMemoryFixture(*fixture); err != nil {
+				log.Fatalln("failed to load the fixture, Error: ", err.Error())
+			}
+		}
+
+		memoryRepo, err := repository.NewMemoryRepository(seed)
+
+		if err != nil {
+			log.Fatalln("invalid fixture, Error: ", err.Error())
+		}
+
+		dbRepo = memoryRepo
+	case "postgres":
+		if *databaseUrl == "" {
+			log.Fatalln("please set -database-url or DATABASE_URL")
+		}
+
+		db := NewDatabase(*databaseUrl)
+
+		db.CheckDatabaseConnection()
+
+		defer db.CloseConnection()
+
+		dbRepo = repository.NewPostgresRepository(db.conn, newTemplateEnvelope(&config.Variables{
+			TemplateKeyFile:    os.Getenv("FINGERPRINT_KEY_FILE"),
+			TemplateKeys:       os.Getenv("FINGERPRINT_KEYS"),
+			TemplateCurrentKey: os.Getenv("FINGERPRINT_CURRENT_KEY"),
+		}))
+	default:
+		log.Fatalln("invalid -repository: ", *repositoryKind)
+	}
+
+	requestTemplate, err := topic.NewTemplate(*requestTopic)
+
+	if err != nil {
+		log.Fatalln("invalid -request-topic, Error: ", err.Error())
+	}
+
+	responseTemplate, err := topic.NewTemplate(*responseTopic)
+
+	if err != nil {
+		log.Fatalln("invalid -response-topic, Error: ", err.Error())
+	}
+
+	if err := requestTemplate.Fills(responseTemplate); err != nil {
+		log.Fatalln("invalid -response-topic, Error: ", err.Error())
+	}
+
+	if *maxChunkSize < 16 || *maxChunkSize > 0xFFFF {
+		log.Fatalln("invalid -max-chunk-size: ", *maxChunkSize)
+	}
+
+	eventSink := newEventSink(processing)
+
+	templateValidator := newTemplateValidator(processing)
+
+	replayClient := capture.NewReplayClient()
+
+	messageProcessor := processor.NewMessageProcessor(
+		replayClient,
+		dbRepo,
+		requestTemplate,
+		responseTemplate,
+		*debounce,
+		*maxSession,
+		eventSink,
+		false,
+		templateValidator,
+		uint16(*maxChunkSize),
+		processing.DeprecatedProtocolVersions,
+		nil,
+		processing.DeadLetterTopic,
+		1,
+		1,
+	)
+
+	replayed := capture.Replay(records, messageProcessor.Process, *speed)
+
+	if err := eventSink.Close(); err != nil {
+		log.Println("failed to flush the event sink, Error: ", err.Error())
+	}
+
+	differences := capture.Diff(records, replayClient.Published(), func(record *capture.Record) bool {
+		messageType, ok := capture.MessageType(record.Payload)
+		return ok && ignored[messageType]
+	})
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]any{
+			"replayed":    replayed,
+			"published":   len(replayClient.Published()),
+			"differences": differences,
+		})
+	} else {
+		fmt.Printf("replayed %v messages, %v responses, %v differences\n", replayed, len(replayClient.Published()), len(differences))
+
+		for _, difference := range differences {
+			fmt.Printf("%v %v #%v\n", difference.Kind, difference.Topic, difference.Index)
+
+			if difference.Recorded != "" {
+				fmt.Printf("  recorded: %v\n", difference.Recorded)
+			}
+
+			if difference.Replayed != "" {
+				fmt.Printf("  replayed: %v\n", difference.Replayed)
+			}
+		}
+	}
+
+	if len(differences) > 0 {
+		os.Exit(1)
+	}
+}
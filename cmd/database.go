@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vithsutra/biometric-project-message-processor/repository"
 )
 
 type database struct {
@@ -21,6 +22,7 @@ func NewDatabase(dbUrl string) *database {
 
 	config.MaxConns = 8
 	config.MinConns = 2
+	config.ConnConfig.Tracer = repository.RoundTripTracer{}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 
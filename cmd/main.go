@@ -5,12 +5,19 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	_ "time/tzdata"
 
 	"github.com/vithsutra/biometric-project-message-processor/config"
+	"github.com/vithsutra/biometric-project-message-processor/repository"
 )
 
 func main() {
 
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	config := config.InitConfig()
 
 	db := NewDatabase(config.DatabaseUrl)
@@ -19,6 +26,13 @@ func main() {
 
 	defer db.CloseConnection()
 
+	//the processor needs the current schema, a deploy only has to start the new image
+	if config.MigrateOnStart == "on" {
+		if err := repository.Migrate(db.conn); err != nil {
+			log.Fatalln("failed to apply the database migrations, Error: ", err.Error())
+		}
+	}
+
 	mqttConn := NewMqttConnection(
 		config.MqttBrokerHost,
 		config.MqttBrokerPort,
@@ -26,7 +40,13 @@ func main() {
 		config.MqttBrokerPassword,
 	)
 
-	go Start(db, mqttConn)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		Start(db, mqttConn, config, stop)
+		close(stopped)
+	}()
 
 	//graceful shutdown
 
@@ -36,4 +56,7 @@ func main() {
 	<-quit
 
 	log.Println("shutting the service down...")
+
+	close(stop)
+	<-stopped
 }
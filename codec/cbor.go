@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// majorMap is the CBOR major type of a map, the first byte of every request.
+const majorMap = 5
+
+// maxCborDepth bounds the nesting a device payload may use, the protocol itself
+// never nests deeper than a struct holding a few scalars.
+const maxCborDepth = 64
+
+// Structs are encoded as maps keyed by their json tag names, honouring omitempty
+// and "-", which fxamacker/cbor falls back to when a field has no cbor tag. Map
+// keys are sorted so the encoding is deterministic. The decoder accepts indefinite
+// length items and skips unknown fields and tags, as produced by common embedded
+// CBOR libraries, and rejects trailing bytes and integers overflowing their field.
+var (
+	cborEncMode cbor.EncMode
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	var err error
+
+	cborEncMode, err = cbor.EncOptions{
+		Sort: cbor.SortBytewiseLexical,
+	}.EncMode()
+
+	if err != nil {
+		panic(err)
+	}
+
+	cborDecMode, err = cbor.DecOptions{
+		MaxNestedLevels: maxCborDepth,
+		DupMapKey:       cbor.DupMapKeyEnforcedAPF,
+	}.DecMode()
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func MarshalCbor(v any) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+func UnmarshalCbor(data []byte, v any) error {
+	return cborDecMode.Unmarshal(data, v)
+}
@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	NameJson = "json"
+	NameCbor = "cbor"
+)
+
+// Codec encodes the request and response structs of the device protocol. Both
+// implementations use the json struct tags of the models as field names.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return NameJson
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) Name() string {
+	return NameCbor
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return MarshalCbor(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return UnmarshalCbor(data, v)
+}
+
+var (
+	Json Codec = jsonCodec{}
+	Cbor Codec = cborCodec{}
+)
+
+func ByName(name string) (Codec, bool) {
+	switch strings.ToLower(name) {
+	case NameJson:
+		return Json, true
+	case NameCbor:
+		return Cbor, true
+	default:
+		return nil, false
+	}
+}
+
+// Detect picks the codec of a request payload. Every request is an object, which
+// starts with '{' in JSON and with a map header (0xa0 to 0xbf) in CBOR, so the two
+// can not be confused. Empty and unrecognised payloads are treated as JSON.
+func Detect(data []byte) Codec {
+	for _, b := range data {
+		switch {
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n':
+			continue
+		case b>>5 == majorMap:
+			return Cbor
+		default:
+			return Json
+		}
+	}
+
+	return Json
+}
+
+// SplitType removes an encoding suffix from the message type of a topic, so a
+// device can publish to ".../attendance.cbor/..." instead of registering an encoding.
+func SplitType(messageType string) (string, Codec) {
+	base, suffix, ok := strings.Cut(messageType, ".")
+
+	if !ok {
+		return messageType, nil
+	}
+
+	c, ok := ByName(suffix)
+
+	if !ok {
+		return messageType, nil
+	}
+
+	return base, c
+}
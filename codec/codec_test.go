@@ -0,0 +1,326 @@
+package codec
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// protocolModels holds every request and response of the device protocol, once with
+// zero values, which exercise omitempty, and once with the largest values of each field.
+var protocolModels = map[string][]any{
+	"ConnectionRequest": {
+		&models.ConnectionRequest{},
+		&models.ConnectionRequest{Encoding: NameCbor, Version: math.MaxUint8},
+	},
+	"ConnectionUpdateResponse": {
+		&models.ConnectionUpdateResponse{},
+		&models.ConnectionUpdateResponse{MessageType: 1, ErrorStatus: math.MaxUint8, ErrorCode: math.MaxUint8, Version: math.MaxUint8},
+	},
+	"DeleteSyncResponse": {
+		&models.DeleteSyncResponse{},
+		&models.DeleteSyncResponse{MessageType: 2, ErrorStatus: 1, ErrorCode: models.ErrorCodeDatabase, StudentsEmpty: 1, StudentId: math.MaxUint16},
+	},
+	"DeleteSyncAckRequest": {
+		&models.DeleteSyncAckRequest{},
+		&models.DeleteSyncAckRequest{StudentId: math.MaxUint16},
+	},
+	"DeleteSyncAckResponse": {
+		&models.DeleteSyncAckResponse{},
+		&models.DeleteSyncAckResponse{MessageType: 3, ErrorStatus: 2, ErrorCode: models.ErrorCodeUnavailable},
+	},
+	"InsertSyncRequest": {
+		&models.InsertSyncRequest{},
+		&models.InsertSyncRequest{MaxChunkSize: math.MaxUint16},
+	},
+	"InsertSyncResponse": {
+		&models.InsertSyncResponse{},
+		&models.InsertSyncResponse{
+			MessageType:     4,
+			ErrorStatus:     1,
+			ErrorCode:       models.ErrorCodeTemplateTooLarge,
+			StudentsEmpty:   1,
+			StudentId:       math.MaxUint16,
+			FingerPrintData: strings.Repeat("Rk1SACAyMAAA", 64),
+			ChunkSize:       math.MaxUint16,
+			TotalChunks:     math.MaxUint16,
+			TemplateLength:  math.MaxUint32,
+			Checksum:        math.MaxUint32,
+			NextChunk:       math.MaxUint16,
+		},
+	},
+	"InsertSyncChunk": {
+		&models.InsertSyncChunk{},
+		&models.InsertSyncChunk{MessageType: 7, ErrorStatus: 1, ErrorCode: models.ErrorCodeInvalidChunk, StudentId: math.MaxUint16, Sequence: math.MaxUint16, Data: "ünïcode \"quoted\" \\ \n"},
+	},
+	"InsertSyncChunkAckRequest": {
+		&models.InsertSyncChunkAckRequest{},
+		&models.InsertSyncChunkAckRequest{StudentId: math.MaxUint16, Sequence: math.MaxUint16},
+	},
+	"InsertSyncAckRequest": {
+		&models.InsertSyncAckRequest{},
+		&models.InsertSyncAckRequest{StudentId: math.MaxUint16},
+	},
+	"InsertSyncAckResponse": {
+		&models.InsertSyncAckResponse{},
+		&models.InsertSyncAckResponse{MessageType: 5, ErrorStatus: 1, ErrorCode: models.ErrorCodeInternal},
+	},
+	"ThrottledResponse": {
+		&models.ThrottledResponse{},
+		&models.ThrottledResponse{MessageType: 6, ErrorStatus: 2, ErrorCode: models.ErrorCodeThrottled, RetryAfter: math.MaxUint16},
+	},
+	"UnsupportedMessageResponse": {
+		&models.UnsupportedMessageResponse{},
+		&models.UnsupportedMessageResponse{MessageType: models.MessageTypeUnsupported, ErrorStatus: 1, ErrorCode: models.ErrorCodeUnsupportedType, Type: "enrol"},
+	},
+	"SyncAvailableCommand": {
+		&models.SyncAvailableCommand{},
+		&models.SyncAvailableCommand{MessageType: 10, PendingInserts: math.MaxUint16, PendingDeletes: math.MaxUint16},
+	},
+	"UpdateAttendanceRequest": {
+		&models.UpdateAttendanceRequest{},
+		&models.UpdateAttendanceRequest{StudentUnitId: math.MaxUint16, Index: math.MaxUint32, TimeStamp: "2024-01-31 23:59:59"},
+	},
+	"UpdateAttendanceRequestV2": {
+		&models.UpdateAttendanceRequestV2{},
+		&models.UpdateAttendanceRequestV2{StudentUnitId: math.MaxUint16, Index: math.MaxUint32, ScannedAt: math.MaxInt64},
+		&models.UpdateAttendanceRequestV2{ScannedAt: math.MinInt64},
+	},
+	"UpdateAttendanceResponse": {
+		&models.UpdateAttendanceResponse{},
+		&models.UpdateAttendanceResponse{MessageType: 6, ErrorStatus: 1, ErrorCode: models.ErrorCodeUnknownStudent, Index: math.MaxUint32, DuplicateScan: 1},
+	},
+}
+
+func decodeAs(t *testing.T, c Codec, data []byte, like any) any {
+	t.Helper()
+
+	decoded := reflect.New(reflect.TypeOf(like).Elem()).Interface()
+
+	if err := c.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("%v: failed to decode %x, Error: %v", c.Name(), data, err)
+	}
+
+	return decoded
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, values := range protocolModels {
+		t.Run(name, func(t *testing.T) {
+			for _, value := range values {
+				for _, c := range []Codec{Json, Cbor} {
+					data, err := c.Marshal(value)
+
+					if err != nil {
+						t.Fatalf("%v: failed to encode %+v, Error: %v", c.Name(), value, err)
+					}
+
+					if decoded := decodeAs(t, c, data, value); !reflect.DeepEqual(decoded, value) {
+						t.Errorf("%v: round trip of %+v gave %+v", c.Name(), value, decoded)
+					}
+
+					if detected := Detect(data); detected != c {
+						t.Errorf("%v: payload %x detected as %v", c.Name(), data, detected.Name())
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestSameFields checks that both codecs put the same fields on the wire, a field
+// left out by omitempty in one codec and not in the other would change what devices see.
+func TestSameFields(t *testing.T) {
+	for name, values := range protocolModels {
+		t.Run(name, func(t *testing.T) {
+			for _, value := range values {
+				jsonData, err := Json.Marshal(value)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cborData, err := Cbor.Marshal(value)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var fromJson, fromCbor map[string]any
+
+				if err := json.Unmarshal(jsonData, &fromJson); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := UnmarshalCbor(cborData, &fromCbor); err != nil {
+					t.Fatal(err)
+				}
+
+				if len(fromJson) != len(fromCbor) {
+					t.Fatalf("json has fields %v, cbor has fields %v", fromJson, fromCbor)
+				}
+
+				for field := range fromJson {
+					if _, ok := fromCbor[field]; !ok {
+						t.Errorf("field %q of %+v missing in cbor", field, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for name, values := range protocolModels {
+		t.Run(name, func(t *testing.T) {
+			for _, value := range values {
+				for _, c := range []Codec{Json, Cbor} {
+					data, err := c.Marshal(value)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					for i := 0; i < len(data); i++ {
+						decoded := reflect.New(reflect.TypeOf(value).Elem()).Interface()
+
+						if err := c.Unmarshal(data[:i], decoded); err == nil {
+							t.Errorf("%v: truncated payload %x decoded without an error", c.Name(), data[:i])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		codec  Codec
+		data   []byte
+		target any
+	}{
+		{"json uint8", Json, []byte(`{"ver":256}`), &models.ConnectionRequest{}},
+		{"json uint16", Json, []byte(`{"sid":65536}`), &models.DeleteSyncAckRequest{}},
+		{"json uint32", Json, []byte(`{"index":4294967296}`), &models.UpdateAttendanceRequest{}},
+		{"json negative", Json, []byte(`{"sid":-1}`), &models.InsertSyncAckRequest{}},
+		//{"ver": 256}
+		{"cbor uint8", Cbor, []byte{0xa1, 0x63, 'v', 'e', 'r', 0x19, 0x01, 0x00}, &models.ConnectionRequest{}},
+		//{"sid": 65536}
+		{"cbor uint16", Cbor, []byte{0xa1, 0x63, 's', 'i', 'd', 0x1a, 0x00, 0x01, 0x00, 0x00}, &models.DeleteSyncAckRequest{}},
+		//{"index": 4294967296}
+		{"cbor uint32", Cbor, []byte{0xa1, 0x65, 'i', 'n', 'd', 'e', 'x', 0x1b, 0, 0, 0, 1, 0, 0, 0, 0}, &models.UpdateAttendanceRequest{}},
+		//{"sid": -1}
+		{"cbor negative", Cbor, []byte{0xa1, 0x63, 's', 'i', 'd', 0x20}, &models.InsertSyncAckRequest{}},
+		//{"ts": -2^64}
+		{"cbor int64", Cbor, []byte{0xa1, 0x62, 't', 's', 0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &models.UpdateAttendanceRequestV2{}},
+		//a text string claiming 2^63 bytes
+		{"cbor length", Cbor, []byte{0xa1, 0x63, 'e', 'n', 'c', 0x7b, 0x80, 0, 0, 0, 0, 0, 0, 0, 'x'}, &models.ConnectionRequest{}},
+		//a map claiming 2^32 entries
+		{"cbor map length", Cbor, []byte{0xba, 0xff, 0xff, 0xff, 0xff}, &models.ConnectionRequest{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.codec.Unmarshal(test.data, test.target); err == nil {
+				t.Errorf("decoded %x into %+v without an error", test.data, test.target)
+			}
+		})
+	}
+}
+
+func TestCborCorrupt(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"trailing bytes", []byte{0xa0, 0x00}},
+		{"reserved information", []byte{0xbc}},
+		{"indefinite integer", []byte{0xa1, 0x63, 's', 'i', 'd', 0x1f}},
+		{"unterminated indefinite map", []byte{0xbf, 0x63, 's', 'i', 'd', 0x01}},
+		{"deep nesting", append([]byte{0xa1, 0x61, 'x'}, []byte(strings.Repeat("\x81", 100)+"\x80")...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := UnmarshalCbor(test.data, &models.DeleteSyncAckRequest{}); err == nil {
+				t.Errorf("decoded %x without an error", test.data)
+			}
+		})
+	}
+}
+
+// TestCborInterop decodes payloads as common embedded libraries produce them, with
+// indefinite lengths, unknown fields and small integers in wide encodings.
+func TestCborInterop(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want models.UpdateAttendanceRequestV2
+	}{
+		{
+			"definite",
+			[]byte{0xa3, 0x63, 's', 'i', 'd', 0x07, 0x65, 'i', 'n', 'd', 'e', 'x', 0x18, 0x2a, 0x62, 't', 's', 0x1a, 0x65, 0x00, 0x00, 0x00},
+			models.UpdateAttendanceRequestV2{StudentUnitId: 7, Index: 42, ScannedAt: 0x65000000},
+		},
+		{
+			"indefinite map and wide integers",
+			[]byte{0xbf, 0x63, 's', 'i', 'd', 0x1b, 0, 0, 0, 0, 0, 0, 0, 0x07, 0x63, 'e', 'x', 't', 0x80, 0x62, 't', 's', 0x38, 0x63, 0xff},
+			models.UpdateAttendanceRequestV2{StudentUnitId: 7, ScannedAt: -100},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got models.UpdateAttendanceRequestV2
+
+			if err := UnmarshalCbor(test.data, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// FuzzUnmarshalCbor feeds malformed and truncated payloads to every protocol model.
+// Decoding may fail but must not panic, and whatever decodes must encode again.
+func FuzzUnmarshalCbor(f *testing.F) {
+	for _, values := range protocolModels {
+		for _, value := range values {
+			data, err := Cbor.Marshal(value)
+
+			if err != nil {
+				f.Fatal(err)
+			}
+
+			f.Add(data)
+			f.Add(data[:len(data)/2])
+		}
+	}
+
+	f.Add([]byte{0xbf, 0x63, 's', 'i', 'd', 0x01})
+	f.Add([]byte{0xba, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xa1, 0x63, 'e', 'n', 'c', 0x7b, 0x80, 0, 0, 0, 0, 0, 0, 0, 'x'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, values := range protocolModels {
+			decoded := reflect.New(reflect.TypeOf(values[0]).Elem()).Interface()
+
+			if err := Cbor.Unmarshal(data, decoded); err != nil {
+				continue
+			}
+
+			if _, err := Cbor.Marshal(decoded); err != nil {
+				t.Errorf("decoded %x into %+v, which does not encode again: %v", data, decoded, err)
+			}
+		}
+	})
+}
@@ -3,16 +3,50 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/vithsutra/biometric-project-message-processor/ratelimit"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+const (
+	DefaultMqttRequestTopic  = "{device}/process/{type}/message"
+	DefaultMqttResponseTopic = "{device}"
 )
 
 type Variables struct {
-	DatabaseUrl        string
-	MqttBrokerHost     string
-	MqttBrokerPort     string
-	MqttBrokerUserName string
-	MqttBrokerPassword string
+	Processing
+	DatabaseUrl               string
+	MqttBrokerHost            string
+	MqttBrokerPort            string
+	MqttBrokerUserName        string
+	MqttBrokerPassword        string
+	WebhookTimeout            time.Duration
+	WebhookMaxAttempts        int
+	WebhookRetention          time.Duration
+	AdminHttpAddress          string
+	AdminApiToken             string
+	SyncNotifyMode            string
+	SyncNotifyInterval        time.Duration
+	TemplateKeyFile           string
+	TemplateKeys              string
+	TemplateCurrentKey        string
+	CaptureFile               string
+	CaptureMaxBytes           int64
+	CaptureMaxFiles           int
+	RateLimits                map[string]ratelimit.Rule
+	DatabaseBreakerFailures   int
+	DatabaseBreakerCooldown   time.Duration
+	DeviceCacheMode           string
+	DeviceCacheTtl            time.Duration
+	DeviceCacheMaxEntries     int
+	AttendanceWriteBehindMode string
+	AttendanceFlushInterval   time.Duration
+	AttendanceBatchSize       int
+	MigrateOnStart            string
 }
 
 func InitConfig() *Variables {
@@ -60,11 +94,278 @@ func InitConfig() *Variables {
 		log.Fatalln("missing or empty MQTT_BROKER_PASSWORD")
 	}
 
+	processing := InitProcessingConfig()
+
+	webhookTimeoutSeconds := getUintEnv("WEBHOOK_TIMEOUT_SECONDS", 10)
+
+	webhookMaxAttempts := getUintEnv("WEBHOOK_MAX_ATTEMPTS", 10)
+
+	webhookRetentionHours := getUintEnv("WEBHOOK_RETENTION_HOURS", 168)
+
+	adminHttpAddress := os.Getenv("ADMIN_HTTP_ADDR")
+
+	adminApiToken := os.Getenv("ADMIN_API_TOKEN")
+
+	if adminHttpAddress != "" && adminApiToken == "" {
+		log.Fatalln("missing or empty ADMIN_API_TOKEN env variable, it is required when ADMIN_HTTP_ADDR is set")
+	}
+
+	syncNotifyMode := os.Getenv("SYNC_NOTIFY_MODE")
+
+	if syncNotifyMode == "" {
+		syncNotifyMode = "listen"
+	}
+
+	if syncNotifyMode != "listen" && syncNotifyMode != "poll" && syncNotifyMode != "off" {
+		log.Fatalln("please set SYNC_NOTIFY_MODE to listen, poll or off")
+	}
+
+	syncNotifyIntervalSeconds := getUintEnv("SYNC_NOTIFY_POLL_SECONDS", 30)
+
+	if syncNotifyIntervalSeconds == 0 {
+		log.Fatalln("invalid SYNC_NOTIFY_POLL_SECONDS env variable, it must be a positive number of seconds")
+	}
+
+	templateKeyFile := os.Getenv("FINGERPRINT_KEY_FILE")
+
+	templateKeys := os.Getenv("FINGERPRINT_KEYS")
+
+	templateCurrentKey := os.Getenv("FINGERPRINT_CURRENT_KEY")
+
+	if templateKeys != "" && templateCurrentKey == "" {
+		log.Fatalln("missing or empty FINGERPRINT_CURRENT_KEY env variable, it is required when FINGERPRINT_KEYS is set")
+	}
+
+	captureMaxMegabytes := getUintEnv("CAPTURE_MAX_MEGABYTES", 100)
+
+	captureMaxFiles := getUintEnv("CAPTURE_MAX_FILES", 5)
+
+	databaseBreakerFailures := getUintEnv("DB_BREAKER_FAILURES", 5)
+
+	databaseBreakerCooldownSeconds := getUintEnv("DB_BREAKER_COOLDOWN_SECONDS", 10)
+
+	if databaseBreakerCooldownSeconds == 0 {
+		log.Fatalln("invalid DB_BREAKER_COOLDOWN_SECONDS env variable, it must be a positive number of seconds")
+	}
+
+	deviceCacheMode := os.Getenv("DEVICE_CACHE")
+
+	if deviceCacheMode == "" {
+		deviceCacheMode = "on"
+	}
+
+	if deviceCacheMode != "on" && deviceCacheMode != "off" {
+		log.Fatalln("please set DEVICE_CACHE to on or off")
+	}
+
+	deviceCacheTtlSeconds := getUintEnv("DEVICE_CACHE_TTL_SECONDS", 300)
+
+	deviceCacheMaxEntries := getUintEnv("DEVICE_CACHE_MAX_ENTRIES", 10000)
+
+	if deviceCacheMode == "on" && (deviceCacheTtlSeconds == 0 || deviceCacheMaxEntries == 0) {
+		log.Fatalln("invalid DEVICE_CACHE_TTL_SECONDS or DEVICE_CACHE_MAX_ENTRIES env variable, set DEVICE_CACHE=off to bypass the cache")
+	}
+
+	attendanceWriteBehindMode := os.Getenv("ATTENDANCE_WRITE_BEHIND")
+
+	if attendanceWriteBehindMode == "" {
+		attendanceWriteBehindMode = "off"
+	}
+
+	if attendanceWriteBehindMode != "on" && attendanceWriteBehindMode != "off" {
+		log.Fatalln("please set ATTENDANCE_WRITE_BEHIND to on or off")
+	}
+
+	attendanceFlushMilliseconds := getUintEnv("ATTENDANCE_FLUSH_MILLISECONDS", 10)
+
+	attendanceBatchSize := getUintEnv("ATTENDANCE_BATCH_SIZE", 100)
+
+	if attendanceWriteBehindMode == "on" && (attendanceFlushMilliseconds == 0 || attendanceBatchSize == 0) {
+		log.Fatalln("invalid ATTENDANCE_FLUSH_MILLISECONDS or ATTENDANCE_BATCH_SIZE env variable, they must be positive numbers")
+	}
+
+	migrateOnStart := os.Getenv("MIGRATE_ON_START")
+
+	if migrateOnStart == "" {
+		migrateOnStart = "on"
+	}
+
+	if migrateOnStart != "on" && migrateOnStart != "off" {
+		log.Fatalln("please set MIGRATE_ON_START to on or off")
+	}
+
+	rateLimits, err := ratelimit.ParseRules(os.Getenv("RATE_LIMITS"))
+
+	if err != nil {
+		log.Fatalln("invalid RATE_LIMITS env variable, Error: ", err.Error())
+	}
+
+	variable.Processing = *processing
 	variable.DatabaseUrl = dbUrl
 	variable.MqttBrokerHost = mqttBrokerHost
 	variable.MqttBrokerPort = mqttBrokerPort
 	variable.MqttBrokerUserName = mqttBrokerUserName
 	variable.MqttBrokerPassword = mqttBrokerPassword
+	variable.WebhookTimeout = time.Duration(webhookTimeoutSeconds) * time.Second
+	variable.WebhookMaxAttempts = int(webhookMaxAttempts)
+	variable.WebhookRetention = time.Duration(webhookRetentionHours) * time.Hour
+	variable.AdminHttpAddress = adminHttpAddress
+	variable.AdminApiToken = adminApiToken
+	variable.SyncNotifyMode = syncNotifyMode
+	variable.SyncNotifyInterval = time.Duration(syncNotifyIntervalSeconds) * time.Second
+	variable.TemplateKeyFile = templateKeyFile
+	variable.TemplateKeys = templateKeys
+	variable.TemplateCurrentKey = templateCurrentKey
+	variable.CaptureFile = os.Getenv("CAPTURE_FILE")
+	variable.CaptureMaxBytes = int64(captureMaxMegabytes) << 20
+	variable.CaptureMaxFiles = int(captureMaxFiles)
+	variable.RateLimits = rateLimits
+	variable.DatabaseBreakerFailures = int(databaseBreakerFailures)
+	variable.DatabaseBreakerCooldown = time.Duration(databaseBreakerCooldownSeconds) * time.Second
+	variable.DeviceCacheMode = deviceCacheMode
+	variable.DeviceCacheTtl = time.Duration(deviceCacheTtlSeconds) * time.Second
+	variable.DeviceCacheMaxEntries = int(deviceCacheMaxEntries)
+	variable.AttendanceWriteBehindMode = attendanceWriteBehindMode
+	variable.AttendanceFlushInterval = time.Duration(attendanceFlushMilliseconds) * time.Millisecond
+	variable.AttendanceBatchSize = int(attendanceBatchSize)
+	variable.MigrateOnStart = migrateOnStart
 
 	return variable
 }
+
+// Processing holds the settings that shape the responses and events of the processor.
+// Replays load them like the service does, so that they reproduce its responses.
+type Processing struct {
+	MqttRequestTopic           string
+	MqttResponseTopic          string
+	AttendanceDebounce         time.Duration
+	AttendanceSession          time.Duration
+	MaxChunkSize               uint16
+	EventSink                  string
+	EventSinkTarget            string
+	EventSinkTopic             string
+	EventQueueSize             int
+	TemplateEncoding           string
+	TemplateLength             int
+	TemplateHeader             string
+	TemplateChecksum           string
+	DeprecatedProtocolVersions []uint8
+	DeadLetterTopic            string
+}
+
+func InitProcessingConfig() *Processing {
+	mqttRequestTopic := os.Getenv("MQTT_REQUEST_TOPIC")
+
+	if mqttRequestTopic == "" {
+		mqttRequestTopic = DefaultMqttRequestTopic
+	}
+
+	mqttResponseTopic := os.Getenv("MQTT_RESPONSE_TOPIC")
+
+	if mqttResponseTopic == "" {
+		mqttResponseTopic = DefaultMqttResponseTopic
+	}
+
+	requestTemplate, err := topic.NewTemplate(mqttRequestTopic)
+
+	if err != nil {
+		log.Fatalln("invalid MQTT_REQUEST_TOPIC env variable, Error: ", err.Error())
+	}
+
+	responseTemplate, err := topic.NewTemplate(mqttResponseTopic)
+
+	if err != nil {
+		log.Fatalln("invalid MQTT_RESPONSE_TOPIC env variable, Error: ", err.Error())
+	}
+
+	//responses are addressed with the values of the request topic
+	if err := requestTemplate.Fills(responseTemplate); err != nil {
+		log.Fatalln("invalid MQTT_RESPONSE_TOPIC env variable, Error: ", err.Error())
+	}
+
+	attendanceDebounceSeconds := getUintEnv("ATTENDANCE_DEBOUNCE_SECONDS", 60)
+
+	attendanceSessionHours := getUintEnv("ATTENDANCE_MAX_SESSION_HOURS", 16)
+
+	if attendanceSessionHours == 0 {
+		log.Fatalln("invalid ATTENDANCE_MAX_SESSION_HOURS env variable, it must be a positive number of hours")
+	}
+
+	maxChunkSize := getUintEnv("INSERT_SYNC_MAX_CHUNK_SIZE", 512)
+
+	if maxChunkSize < 16 || maxChunkSize > 0xFFFF {
+		log.Fatalln("invalid INSERT_SYNC_MAX_CHUNK_SIZE env variable, it must be between 16 and 65535 bytes")
+	}
+
+	eventSink := os.Getenv("EVENT_SINK")
+
+	eventSinkTarget := os.Getenv("EVENT_SINK_TARGET")
+
+	if (eventSink == "file" || eventSink == "nats" || eventSink == "kafka") && eventSinkTarget == "" {
+		log.Fatalln("missing or empty EVENT_SINK_TARGET env variable for EVENT_SINK ", eventSink)
+	}
+
+	eventQueueSize := getUintEnv("EVENT_QUEUE_SIZE", 1000)
+
+	if eventQueueSize == 0 {
+		log.Fatalln("invalid EVENT_QUEUE_SIZE env variable, it must be a positive number")
+	}
+
+	templateLength := getUintEnv("FINGERPRINT_TEMPLATE_LENGTH", 0)
+
+	var deprecatedProtocolVersions []uint8
+
+	for _, value := range strings.Split(os.Getenv("DEPRECATED_PROTOCOL_VERSIONS"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		version, err := strconv.ParseUint(value, 10, 8)
+
+		if err != nil {
+			log.Fatalln("invalid DEPRECATED_PROTOCOL_VERSIONS env variable, Error: ", err.Error())
+		}
+
+		deprecatedProtocolVersions = append(deprecatedProtocolVersions, uint8(version))
+	}
+
+	deadLetterTopic := strings.TrimSpace(os.Getenv("MQTT_DEAD_LETTER_TOPIC"))
+
+	if strings.ContainsAny(deadLetterTopic, "+#") {
+		log.Fatalln("invalid MQTT_DEAD_LETTER_TOPIC env variable, it must not contain mqtt wildcards")
+	}
+
+	return &Processing{
+		MqttRequestTopic:           mqttRequestTopic,
+		MqttResponseTopic:          mqttResponseTopic,
+		AttendanceDebounce:         time.Duration(attendanceDebounceSeconds) * time.Second,
+		AttendanceSession:          time.Duration(attendanceSessionHours) * time.Hour,
+		MaxChunkSize:               uint16(maxChunkSize),
+		EventSink:                  eventSink,
+		EventSinkTarget:            eventSinkTarget,
+		EventSinkTopic:             os.Getenv("EVENT_SINK_TOPIC"),
+		EventQueueSize:             int(eventQueueSize),
+		TemplateEncoding:           os.Getenv("FINGERPRINT_TEMPLATE_ENCODING"),
+		TemplateLength:             int(templateLength),
+		TemplateHeader:             os.Getenv("FINGERPRINT_TEMPLATE_HEADER"),
+		TemplateChecksum:           os.Getenv("FINGERPRINT_TEMPLATE_CHECKSUM"),
+		DeprecatedProtocolVersions: deprecatedProtocolVersions,
+		DeadLetterTopic:            deadLetterTopic,
+	}
+}
+
+func getUintEnv(name string, defaultValue uint64) uint64 {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 32)
+
+	if err != nil {
+		log.Fatalln("invalid ", name, " env variable, Error: ", err.Error())
+	}
+
+	return parsed
+}
@@ -0,0 +1,138 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const envelopeVersion = "v1"
+
+// Envelope encrypts every value with a fresh data key and stores that data key
+// wrapped by the current master key. Values are stored as
+// v1.<base64 wrapped data key>.<base64 ciphertext>, the master key id is kept by
+// the caller next to the value. Both layers are AES-256-GCM with the nonce prepended,
+// and the ciphertext is bound to the associated data of its row.
+type Envelope struct {
+	keys KeyProvider
+}
+
+func NewEnvelope(keys KeyProvider) *Envelope {
+	return &Envelope{
+		keys: keys,
+	}
+}
+
+func (e *Envelope) CurrentKeyId() string {
+	return e.keys.CurrentKeyId()
+}
+
+func (e *Envelope) Encrypt(plaintext []byte, associatedData []byte) (string, string, error) {
+	keyId := e.keys.CurrentKeyId()
+
+	masterKey, ok := e.keys.Key(keyId)
+
+	if !ok {
+		return "", "", fmt.Errorf("unknown key id %q", keyId)
+	}
+
+	dataKey := make([]byte, 32)
+
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", "", err
+	}
+
+	wrappedKey, err := seal(masterKey, dataKey, []byte(keyId))
+
+	if err != nil {
+		return "", "", err
+	}
+
+	ciphertext, err := seal(dataKey, plaintext, associatedData)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	value := envelopeVersion + "." + base64.StdEncoding.EncodeToString(wrappedKey) + "." + base64.StdEncoding.EncodeToString(ciphertext)
+
+	return value, keyId, nil
+}
+
+func (e *Envelope) Decrypt(value string, keyId string, associatedData []byte) ([]byte, error) {
+	parts := strings.Split(value, ".")
+
+	if len(parts) != 3 || parts[0] != envelopeVersion {
+		return nil, errors.New("invalid envelope format")
+	}
+
+	masterKey, ok := e.keys.Key(keyId)
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", keyId)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := open(masterKey, wrappedKey, []byte(keyId))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key: %w", err)
+	}
+
+	return open(dataKey, ciphertext, associatedData)
+}
+
+func seal(key []byte, plaintext []byte, associatedData []byte) ([]byte, error) {
+	aead, err := newGcm(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(key []byte, sealed []byte, associatedData []byte) ([]byte, error) {
+	aead, err := newGcm(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData)
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
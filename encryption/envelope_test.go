@@ -0,0 +1,147 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func testEnvelope(t *testing.T, spec string, current string) *Envelope {
+	t.Helper()
+
+	keys, err := NewEnvKeyProvider(spec, current)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewEnvelope(keys)
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	envelope := testEnvelope(t, "k1:"+testKey(1), "k1")
+
+	plaintext := []byte("Rk1SACAyMAAA")
+	row := []byte("vs23cg003/7")
+
+	value, keyId, err := envelope.Encrypt(plaintext, row)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keyId != "k1" || !strings.HasPrefix(value, envelopeVersion+".") || strings.Contains(value, string(plaintext)) {
+		t.Fatalf("got %q with key %q, want a v1 envelope under k1", value, keyId)
+	}
+
+	decrypted, err := envelope.Decrypt(value, keyId, row)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("got %q, want %q", decrypted, plaintext)
+	}
+
+	//every value gets a data key and nonces of its own
+	if again, _, _ := envelope.Encrypt(plaintext, row); again == value {
+		t.Error("encrypting twice gave the same envelope")
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	envelope := testEnvelope(t, "k1:"+testKey(1), "k1")
+
+	row := []byte("vs23cg003/7")
+
+	value, keyId, err := envelope.Encrypt([]byte("Rk1SACAyMAAA"), row)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(value, ".")
+
+	flip := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		raw[len(raw)-1] ^= 1
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		row   []byte
+	}{
+		{"wrapped key", parts[0] + "." + flip(parts[1]) + "." + parts[2], row},
+		{"ciphertext", parts[0] + "." + parts[1] + "." + flip(parts[2]), row},
+		{"other row", value, []byte("vs23cg003/8")},
+		{"swapped layers", parts[0] + "." + parts[2] + "." + parts[1], row},
+		{"truncated ciphertext", parts[0] + "." + parts[1] + ".AAAA", row},
+		{"unknown version", "v2." + parts[1] + "." + parts[2], row},
+		{"missing part", parts[0] + "." + parts[1], row},
+		{"invalid base64", parts[0] + "." + parts[1] + ".!!", row},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := envelope.Decrypt(test.value, keyId, test.row); err == nil {
+				t.Errorf("decrypted %q without an error", test.value)
+			}
+		})
+	}
+}
+
+func TestEnvelopeKeyRotation(t *testing.T) {
+	old := testEnvelope(t, "k1:"+testKey(1), "k1")
+
+	value, keyId, err := old.Encrypt([]byte("Rk1SACAyMAAA"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := testEnvelope(t, "k1:"+testKey(1)+",k2:"+testKey(2), "k2")
+
+	//values of the retired key still decrypt, new ones use the current key
+	if _, err := rotated.Decrypt(value, keyId, nil); err != nil {
+		t.Errorf("failed to decrypt a value of %v after the rotation: %v", keyId, err)
+	}
+
+	if _, newKeyId, err := rotated.Encrypt([]byte("Rk1SACAyMAAA"), nil); err != nil || newKeyId != "k2" {
+		t.Errorf("got key %q and error %v, want the current key k2", newKeyId, err)
+	}
+
+	if rotated.CurrentKeyId() != "k2" {
+		t.Errorf("got current key %q, want k2", rotated.CurrentKeyId())
+	}
+}
+
+func TestEnvelopeWrongKeyId(t *testing.T) {
+	envelope := testEnvelope(t, "k1:"+testKey(1)+",k2:"+testKey(2), "k1")
+
+	value, _, err := envelope.Encrypt([]byte("Rk1SACAyMAAA"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the key id is bound to the wrapped key, a value stored under another id fails
+	if _, err := envelope.Decrypt(value, "k2", nil); err == nil {
+		t.Error("decrypted with the wrong key id")
+	}
+
+	if _, err := envelope.Decrypt(value, "k3", nil); err == nil || !strings.Contains(err.Error(), "unknown key id") {
+		t.Errorf("got %v for a missing key id, want unknown key id", err)
+	}
+
+	if _, err := envelope.Decrypt(value, "", nil); err == nil {
+		t.Error("decrypted without a key id")
+	}
+}
@@ -0,0 +1,110 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type KeyProvider interface {
+	CurrentKeyId() string
+	Key(keyId string) ([]byte, bool)
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+type staticKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (k *staticKeys) CurrentKeyId() string {
+	return k.current
+}
+
+func (k *staticKeys) Key(keyId string) ([]byte, bool) {
+	key, ok := k.keys[keyId]
+	return key, ok
+}
+
+// NewEnvKeyProvider parses keys in the form "k1:<base64>,k2:<base64>".
+func NewEnvKeyProvider(spec string, current string) (KeyProvider, error) {
+	encoded := make(map[string]string)
+
+	for i, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+
+		if entry == "" {
+			continue
+		}
+
+		keyId, key, ok := strings.Cut(entry, ":")
+
+		//the entry is not printed, without a separator it may be the key itself
+		if !ok {
+			return nil, fmt.Errorf("invalid key entry number %v, expected <key id>:<base64 key>", i+1)
+		}
+
+		encoded[keyId] = key
+	}
+
+	return newStaticKeys(current, encoded)
+}
+
+// NewFileKeyProvider reads {"current":"k2","keys":{"k1":"<base64>","k2":"<base64>"}}.
+func NewFileKeyProvider(path string) (KeyProvider, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file := new(keyFile)
+
+	if err := json.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+
+	return newStaticKeys(file.Current, file.Keys)
+}
+
+func newStaticKeys(current string, encoded map[string]string) (*staticKeys, error) {
+	if current == "" {
+		return nil, errors.New("missing current key id")
+	}
+
+	keys := make(map[string][]byte, len(encoded))
+
+	for keyId, value := range encoded {
+		if keyId == "" {
+			return nil, errors.New("empty key id")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 for key %q: %w", keyId, err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes for AES-256, got %v", keyId, len(key))
+		}
+
+		keys[keyId] = key
+	}
+
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key set", current)
+	}
+
+	return &staticKeys{
+		current: current,
+		keys:    keys,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewEnvKeyProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		current string
+		wantErr bool
+	}{
+		{"single key", "k1:" + testKey(1), "k1", false},
+		{"rotated keys", " k1:" + testKey(1) + " , k2:" + testKey(2) + ",", "k2", false},
+		{"missing current", "k1:" + testKey(1), "", true},
+		{"current not in set", "k1:" + testKey(1), "k2", true},
+		{"no separator", testKey(1), "k1", true},
+		{"empty key id", ":" + testKey(1), "k1", true},
+		{"invalid base64", "k1:not base64", "k1", true},
+		{"short key", "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "k1", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := NewEnvKeyProvider(test.spec, test.current)
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if _, ok := keys.Key(test.current); !ok || keys.CurrentKeyId() != test.current {
+				t.Errorf("got current key %q, want %q", keys.CurrentKeyId(), test.current)
+			}
+		})
+	}
+}
+
+func writeKeyFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNewFileKeyProvider(t *testing.T) {
+	keys, err := NewFileKeyProvider(writeKeyFile(t, `{"current":"k2","keys":{"k1":"`+testKey(1)+`","k2":"`+testKey(2)+`"}}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys.CurrentKeyId() != "k2" {
+		t.Errorf("got current key %q, want k2", keys.CurrentKeyId())
+	}
+
+	if _, ok := keys.Key("k1"); !ok {
+		t.Error("the retired key k1 is missing")
+	}
+
+	if _, ok := keys.Key("k3"); ok {
+		t.Error("got a key for the unknown id k3")
+	}
+
+	for _, content := range []string{`{"current":"k1"`, `{"current":"k3","keys":{"k1":"` + testKey(1) + `"}}`} {
+		if _, err := NewFileKeyProvider(writeKeyFile(t, content)); err == nil {
+			t.Errorf("loaded %v without an error", content)
+		}
+	}
+
+	if _, err := NewFileKeyProvider(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing key file without an error")
+	}
+}
+
+func TestNewKeyProviderFromConfig(t *testing.T) {
+	path := writeKeyFile(t, `{"current":"file","keys":{"file":"`+testKey(1)+`"}}`)
+
+	//the key file takes precedence over the inline keys
+	keys, err := NewKeyProviderFromConfig(path, "env:"+testKey(2), "env")
+
+	if err != nil || keys.CurrentKeyId() != "file" {
+		t.Errorf("got %v and error %v, want the keys of the file", keys, err)
+	}
+
+	keys, err = NewKeyProviderFromConfig("", "env:"+testKey(2), "env")
+
+	if err != nil || keys.CurrentKeyId() != "env" {
+		t.Errorf("got %v and error %v, want the inline keys", keys, err)
+	}
+
+	//nothing configured keeps templates in plaintext
+	if keys, err := NewKeyProviderFromConfig("", "", ""); keys != nil || err != nil {
+		t.Errorf("got %v and error %v, want no provider", keys, err)
+	}
+}
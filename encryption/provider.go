@@ -0,0 +1,15 @@
+package encryption
+
+// NewKeyProviderFromConfig prefers the key file over the inline keys, and returns
+// a nil provider when neither is configured, which leaves templates in plaintext.
+func NewKeyProviderFromConfig(keyFile string, keys string, current string) (KeyProvider, error) {
+	if keyFile != "" {
+		return NewFileKeyProvider(keyFile)
+	}
+
+	if keys != "" {
+		return NewEnvKeyProvider(keys, current)
+	}
+
+	return nil, nil
+}
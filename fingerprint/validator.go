@@ -0,0 +1,114 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+
+	ChecksumNone  = "none"
+	ChecksumSum16 = "sum16"
+)
+
+// Validator checks templates against the sensor format before they are sent to a
+// device. With an empty Encoding only empty templates are rejected.
+type Validator struct {
+	Encoding string
+	Length   int
+	Header   []byte
+	Checksum string
+}
+
+func NewValidator(encoding string, length int, headerHex string, checksum string) (*Validator, error) {
+	switch encoding {
+	case "", EncodingHex, EncodingBase64:
+	default:
+		return nil, fmt.Errorf("unknown template encoding %q", encoding)
+	}
+
+	if checksum == "" {
+		checksum = ChecksumNone
+	}
+
+	if checksum != ChecksumNone && checksum != ChecksumSum16 {
+		return nil, fmt.Errorf("unknown template checksum %q", checksum)
+	}
+
+	header, err := hex.DecodeString(headerHex)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid template header: %w", err)
+	}
+
+	if encoding == "" && (length > 0 || len(header) > 0 || checksum != ChecksumNone) {
+		return nil, errors.New("template length, header and checksum checks need a template encoding")
+	}
+
+	return &Validator{
+		Encoding: encoding,
+		Length:   length,
+		Header:   header,
+		Checksum: checksum,
+	}, nil
+}
+
+func (v *Validator) Validate(data string) error {
+	data = strings.TrimSpace(data)
+
+	if data == "" {
+		return errors.New("empty template")
+	}
+
+	if v.Encoding == "" {
+		return nil
+	}
+
+	template, err := v.decode(data)
+
+	if err != nil {
+		return fmt.Errorf("invalid %v encoding: %w", v.Encoding, err)
+	}
+
+	if v.Length > 0 && len(template) != v.Length {
+		return fmt.Errorf("template is %v bytes, expected %v", len(template), v.Length)
+	}
+
+	if !bytes.HasPrefix(template, v.Header) {
+		return fmt.Errorf("template header mismatch, expected %x", v.Header)
+	}
+
+	if v.Checksum == ChecksumSum16 {
+		if len(template) < 2 {
+			return errors.New("template too short for a checksum")
+		}
+
+		body := template[:len(template)-2]
+
+		var sum uint16
+
+		for _, b := range body {
+			sum += uint16(b)
+		}
+
+		if expected := binary.BigEndian.Uint16(template[len(template)-2:]); sum != expected {
+			return fmt.Errorf("template checksum %04x does not match %04x", sum, expected)
+		}
+	}
+
+	return nil
+}
+
+func (v *Validator) decode(data string) ([]byte, error) {
+	if v.Encoding == EncodingHex {
+		return hex.DecodeString(data)
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
@@ -0,0 +1,136 @@
+package fingerprint
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// sensorTemplate returns a template of length bytes starting with "FMR" and ending
+// with the sum16 checksum of the bytes before it.
+func sensorTemplate(length int) []byte {
+	template := make([]byte, length)
+	copy(template, "FMR")
+
+	var sum uint16
+
+	for i := 3; i < length-2; i++ {
+		template[i] = byte(i)
+	}
+
+	for _, b := range template[:length-2] {
+		sum += uint16(b)
+	}
+
+	binary.BigEndian.PutUint16(template[length-2:], sum)
+
+	return template
+}
+
+func TestNewValidator(t *testing.T) {
+	tests := []struct {
+		name      string
+		encoding  string
+		length    int
+		headerHex string
+		checksum  string
+		wantErr   bool
+	}{
+		{"no checks", "", 0, "", "", false},
+		{"all checks", EncodingBase64, 512, "464d52", ChecksumSum16, false},
+		{"hex", EncodingHex, 0, "", ChecksumNone, false},
+		{"unknown encoding", "base32", 0, "", "", true},
+		{"unknown checksum", EncodingHex, 0, "", "crc32", true},
+		{"invalid header", EncodingHex, 0, "46x", "", true},
+		{"length without encoding", "", 512, "", "", true},
+		{"header without encoding", "", 0, "464d52", "", true},
+		{"checksum without encoding", "", 0, "", ChecksumSum16, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewValidator(test.encoding, test.length, test.headerHex, test.checksum); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := sensorTemplate(32)
+
+	badChecksum := sensorTemplate(32)
+	badChecksum[10] ^= 1
+
+	badHeader := sensorTemplate(32)
+	badHeader[0] = 'X'
+
+	tests := []struct {
+		name     string
+		encoding string
+		length   int
+		checksum string
+		data     string
+		wantErr  bool
+	}{
+		{"base64", EncodingBase64, 32, ChecksumSum16, base64.StdEncoding.EncodeToString(valid), false},
+		{"hex", EncodingHex, 32, ChecksumSum16, hex.EncodeToString(valid), false},
+		{"surrounding whitespace", EncodingBase64, 32, ChecksumSum16, " " + base64.StdEncoding.EncodeToString(valid) + "\n", false},
+		{"any length", EncodingBase64, 0, ChecksumSum16, base64.StdEncoding.EncodeToString(sensorTemplate(40)), false},
+		{"empty", EncodingBase64, 32, ChecksumSum16, "", true},
+		{"blank", EncodingBase64, 32, ChecksumSum16, "  ", true},
+		{"invalid base64", EncodingBase64, 32, ChecksumSum16, "Rk1S*", true},
+		{"invalid hex", EncodingHex, 32, ChecksumSum16, "464d5", true},
+		{"hex as base64", EncodingBase64, 32, ChecksumSum16, hex.EncodeToString(valid), true},
+		{"too short", EncodingBase64, 32, ChecksumSum16, base64.StdEncoding.EncodeToString(valid[:31]), true},
+		{"too long", EncodingBase64, 32, ChecksumSum16, base64.StdEncoding.EncodeToString(append(valid, 0)), true},
+		{"header", EncodingBase64, 32, ChecksumNone, base64.StdEncoding.EncodeToString(badHeader), true},
+		{"header shorter than prefix", EncodingBase64, 0, ChecksumNone, base64.StdEncoding.EncodeToString([]byte("FM")), true},
+		{"checksum", EncodingBase64, 32, ChecksumSum16, base64.StdEncoding.EncodeToString(badChecksum), true},
+		{"checksum ignored", EncodingBase64, 32, ChecksumNone, base64.StdEncoding.EncodeToString(badChecksum), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validator, err := NewValidator(test.encoding, test.length, "464d52", test.checksum)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := validator.Validate(test.data); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateWithoutEncoding(t *testing.T) {
+	validator, err := NewValidator("", 0, "", "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//without an encoding anything but an empty template passes
+	if err := validator.Validate("not a template"); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+
+	if err := validator.Validate(" "); err == nil {
+		t.Error("validated an empty template")
+	}
+}
+
+func TestValidateChecksumTooShort(t *testing.T) {
+	validator, err := NewValidator(EncodingHex, 0, "", ChecksumSum16)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validator.Validate("46"); err == nil {
+		t.Error("validated a template shorter than its checksum")
+	}
+}
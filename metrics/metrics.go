@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+// Counters are published with expvar and served as JSON by the admin api.
+var (
+	ProtocolMessages           = expvar.NewMap("protocol_messages")
+	DeprecatedProtocolMessages = expvar.NewMap("deprecated_protocol_messages")
+	DeprecatedProtocolDevices  = expvar.NewMap("deprecated_protocol_devices")
+	MessagesProcessed          = expvar.NewMap("messages_processed")
+	MessageQueueFull           = expvar.NewInt("message_queue_full")
+	ThrottledMessages          = expvar.NewMap("throttled_messages")
+	DatabaseCircuitOpened      = expvar.NewInt("database_circuit_opened")
+	DatabaseUnavailable        = expvar.NewInt("database_unavailable")
+	RejectedMessages           = expvar.NewMap("rejected_messages")
+	CacheHits                  = expvar.NewMap("cache_hits")
+	CacheMisses                = expvar.NewMap("cache_misses")
+	CacheEvictions             = expvar.NewMap("cache_evictions")
+	DatabaseRoundTrips         = expvar.NewInt("database_round_trips")
+	AttendanceBatches          = expvar.NewInt("attendance_batches")
+	AttendanceBatchedWrites    = expvar.NewInt("attendance_batched_writes")
+	AttendanceBatchFallbacks   = expvar.NewInt("attendance_batch_fallbacks")
+	EventsDropped              = expvar.NewInt("events_dropped")
+	EventSinkErrors            = expvar.NewInt("event_sink_errors")
+)
+
+type QueueStats struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// PublishQueue exposes the current length of a queue, only the first queue
+// published under a name is kept.
+func PublishQueue(name string, stats func() QueueStats) {
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return stats()
+	}))
+}
+
+type CacheStats struct {
+	Entries    int `json:"entries"`
+	MaxEntries int `json:"max_entries"`
+}
+
+// PublishCache exposes the current number of entries of a cache, only the first
+// cache published under a name is kept.
+func PublishCache(name string, stats func() CacheStats) {
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return stats()
+	}))
+}
+
+// PublishCounts exposes counts computed on every read, such as per device counts
+// kept by a component that bounds them. Only the first counts published under a name
+// are kept.
+func PublishCounts(name string, counts func() map[string]int64) {
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return counts()
+	}))
+}
+
+// PublishState exposes a state such as the position of a circuit breaker, only the
+// first state published under a name is kept.
+func PublishState(name string, state func() string) {
+	if expvar.Get(name) != nil {
+		return
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return state()
+	}))
+}
+
+func Handler() http.Handler {
+	return expvar.Handler()
+}
+
+func Text(value string) expvar.Var {
+	text := new(expvar.String)
+	text.Set(value)
+	return text
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict")
+
+	// ErrUnavailable is returned without touching the database while it is considered down.
+	ErrUnavailable = errors.New("database unavailable")
+
+	// ErrTemplateUndecryptable marks a stored fingerprint template that fails to decrypt
+	// with the configured keys, retrying does not help.
+	ErrTemplateUndecryptable = errors.New("undecryptable fingerprint template")
+)
+
+const (
+	SyncQueueInserts = "inserts"
+	SyncQueueDeletes = "deletes"
+)
+
+type DeviceStatus struct {
+	UnitId         string     `json:"unit_id"`
+	Online         bool       `json:"online"`
+	LastSeenAt     *time.Time `json:"last_seen_at"`
+	PendingInserts int        `json:"pending_inserts"`
+	PendingDeletes int        `json:"pending_deletes"`
+}
+
+type SyncItem struct {
+	StudentUnitId    string     `json:"student_unit_id"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason *string    `json:"quarantine_reason,omitempty"`
+}
+
+type PunchRecord struct {
+	StudentId     string    `json:"student_id"`
+	StudentUnitId string    `json:"student_unit_id"`
+	Index         uint32    `json:"index"`
+	ScannedAt     time.Time `json:"scanned_at"`
+	ReceivedAt    time.Time `json:"received_at"`
+	BusinessDate  string    `json:"date"`
+}
+
+// ThrottledDevice is a device that went over the rate limit of a message type recently.
+type ThrottledDevice struct {
+	DeviceId        string    `json:"device_id"`
+	MessageType     string    `json:"message_type"`
+	Throttled       int64     `json:"throttled"`
+	LastThrottledAt time.Time `json:"last_throttled_at"`
+}
+
+type ThrottledDevicesInterface interface {
+	ThrottledDevices() []ThrottledDevice
+}
+
+type AdminDatabaseInterface interface {
+	ListDevices() ([]DeviceStatus, error)
+	GetDevice(deviceId string) (*DeviceStatus, error)
+	ListSyncItems(deviceId string, queue string) ([]SyncItem, error)
+	CancelSyncItem(deviceId string, queue string, studentUnitId string) error
+	RequeueSyncItem(deviceId string, queue string, studentUnitId string) error
+	GetRecentPunches(deviceId string, limit int) ([]PunchRecord, error)
+}
+
+type PendingSync struct {
+	UnitId  string
+	Inserts int
+	Deletes int
+}
+
+type RegistryNotifyDatabaseInterface interface {
+	ListenRegistry(onDevice func(unitId string), onStudent func(unitId string, studentUnitId string), onReset func()) error
+}
+
+type SyncNotifyDatabaseInterface interface {
+	ListOnlinePendingSync() ([]PendingSync, error)
+	ListenSyncQueue(handler func(deviceId string)) error
+}
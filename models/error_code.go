@@ -0,0 +1,43 @@
+package models
+
+// Error statuses sent in "est". Firmware that predates the error codes only reads
+// this field, so its meaning never changes: zero is success, ErrorStatusFailed is
+// a failure and ErrorStatusRetryLater asks the device to send the request again
+// after a while.
+const (
+	ErrorStatusOk         uint8 = 0
+	ErrorStatusFailed     uint8 = 1
+	ErrorStatusRetryLater uint8 = 2
+)
+
+// Error codes sent in "ecd" next to a non zero "est", they tell the device why a
+// request failed so that it can decide between retrying and discarding it. New
+// codes are only ever appended.
+const (
+	ErrorCodeNone                uint8 = 0
+	ErrorCodeInternal            uint8 = 1
+	ErrorCodeDatabase            uint8 = 2
+	ErrorCodeUnavailable         uint8 = 3
+	ErrorCodeThrottled           uint8 = 4
+	ErrorCodeUnknownDevice       uint8 = 5
+	ErrorCodeUnknownStudent      uint8 = 6
+	ErrorCodeInvalidPayload      uint8 = 7
+	ErrorCodeInvalidTimestamp    uint8 = 8
+	ErrorCodeUnsupportedVersion  uint8 = 9
+	ErrorCodeDeviceMisconfigured uint8 = 10
+	ErrorCodeTemplateTooLarge    uint8 = 11
+	ErrorCodeInvalidChunk        uint8 = 12
+	ErrorCodeUnsupportedType     uint8 = 13
+)
+
+// ErrorStatusFor maps an error code to the "est" older firmware understands.
+func ErrorStatusFor(code uint8) uint8 {
+	switch code {
+	case ErrorCodeNone:
+		return ErrorStatusOk
+	case ErrorCodeUnavailable, ErrorCodeThrottled:
+		return ErrorStatusRetryLater
+	default:
+		return ErrorStatusFailed
+	}
+}
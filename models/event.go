@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// names of the events, the same for the event sink and the webhook outbox
+const (
+	EventDeviceConnected    = "device.connected"
+	EventDeviceDisconnected = "device.disconnected"
+	EventAttendanceLogin    = "attendance.login"
+	EventAttendanceLogout   = "attendance.logout"
+	EventEnrollmentSynced   = "enrollment.synced"
+	EventEnrollmentRemoved  = "enrollment.removed"
+)
+
+// schema versions of the event data, bump on any incompatible change of the payload
+var EventSchemaVersions = map[string]int{
+	EventDeviceConnected:    1,
+	EventDeviceDisconnected: 1,
+	EventAttendanceLogin:    1,
+	EventAttendanceLogout:   1,
+	EventEnrollmentSynced:   1,
+	EventEnrollmentRemoved:  1,
+}
+
+type Event struct {
+	Id         string    `json:"id"`
+	Type       string    `json:"type"`
+	Version    int       `json:"version"`
+	Source     string    `json:"source"`
+	DeviceId   string    `json:"device_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+type AttendanceRecordedData struct {
+	StudentId     string    `json:"student_id"`
+	StudentUnitId string    `json:"student_unit_id"`
+	Index         uint32    `json:"index"`
+	Direction     string    `json:"direction"`
+	Date          string    `json:"date"`
+	ScannedAt     time.Time `json:"scanned_at"`
+}
+
+type DeviceStatusData struct {
+	Online bool `json:"online"`
+}
+
+type TemplateSyncedData struct {
+	StudentUnitId string `json:"student_unit_id"`
+	Operation     string `json:"operation"`
+}
+
+type EventSink interface {
+	Publish(event *Event) error
+	Close() error
+}
@@ -1,12 +1,22 @@
 package models
 
+import "time"
+
+type ConnectionRequest struct {
+	Encoding string `json:"enc,omitempty"`
+	Version  uint8  `json:"ver,omitempty"`
+}
+
 type ConnectionUpdateResponse struct {
 	MessageType uint8 `json:"mty"`
 	ErrorStatus uint8 `json:"est"`
+	ErrorCode   uint8 `json:"ecd,omitempty"`
+	Version     uint8 `json:"ver,omitempty"`
 }
 type DeleteSyncResponse struct {
 	MessageType   uint8  `json:"mty"`
 	ErrorStatus   uint8  `json:"est"`
+	ErrorCode     uint8  `json:"ecd,omitempty"`
 	StudentsEmpty uint8  `json:"ste"`
 	StudentId     uint16 `json:"sid"`
 }
@@ -18,14 +28,45 @@ type DeleteSyncAckRequest struct {
 type DeleteSyncAckResponse struct {
 	MessageType uint8 `json:"mty"`
 	ErrorStatus uint8 `json:"est"`
+	ErrorCode   uint8 `json:"ecd,omitempty"`
+}
+
+type InsertSyncRequest struct {
+	MaxChunkSize uint16 `json:"mcs"`
 }
 
 type InsertSyncResponse struct {
 	MessageType     uint8  `json:"mty"`
 	ErrorStatus     uint8  `json:"est"`
+	ErrorCode       uint8  `json:"ecd,omitempty"`
 	StudentsEmpty   uint8  `json:"ste"`
 	StudentId       uint16 `json:"sid"`
 	FingerPrintData string `json:"fpd"`
+	ChunkSize       uint16 `json:"csz,omitempty"`
+	TotalChunks     uint16 `json:"tch,omitempty"`
+	TemplateLength  uint32 `json:"tln,omitempty"`
+	Checksum        uint32 `json:"crc,omitempty"`
+	NextChunk       uint16 `json:"nxt,omitempty"`
+}
+
+type InsertSyncChunk struct {
+	MessageType uint8  `json:"mty"`
+	ErrorStatus uint8  `json:"est"`
+	ErrorCode   uint8  `json:"ecd,omitempty"`
+	StudentId   uint16 `json:"sid"`
+	Sequence    uint16 `json:"seq"`
+	Data        string `json:"dat"`
+}
+
+type InsertSyncChunkAckRequest struct {
+	StudentId uint16 `json:"sid"`
+	Sequence  uint16 `json:"seq"`
+}
+
+type ChunkTransfer struct {
+	FingerprintData string
+	ChunkSize       int
+	NextChunk       int
 }
 
 type InsertSyncAckRequest struct {
@@ -35,6 +76,43 @@ type InsertSyncAckRequest struct {
 type InsertSyncAckResponse struct {
 	MessageType uint8 `json:"mty"`
 	ErrorStatus uint8 `json:"est"`
+	ErrorCode   uint8 `json:"ecd,omitempty"`
+}
+
+// ThrottledResponse answers a request that went over the rate limit of the device,
+// RetryAfter is in seconds.
+type ThrottledResponse struct {
+	MessageType uint8  `json:"mty"`
+	ErrorStatus uint8  `json:"est"`
+	ErrorCode   uint8  `json:"ecd,omitempty"`
+	RetryAfter  uint16 `json:"rta"`
+}
+
+// MessageTypeUnsupported is the mty of the reply to a message the server does not
+// understand, Type echoes the message type of the topic when there is one.
+const MessageTypeUnsupported uint8 = 9
+
+type UnsupportedMessageResponse struct {
+	MessageType uint8  `json:"mty"`
+	ErrorStatus uint8  `json:"est"`
+	ErrorCode   uint8  `json:"ecd"`
+	Type        string `json:"typ,omitempty"`
+}
+
+// DeadLetter wraps a message that could not be processed, it is published as json
+// to the dead letter topic.
+type DeadLetter struct {
+	Topic      string    `json:"topic"`
+	DeviceId   string    `json:"device_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Payload    []byte    `json:"payload"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type SyncAvailableCommand struct {
+	MessageType    uint8  `json:"mty"`
+	PendingInserts uint16 `json:"pin"`
+	PendingDeletes uint16 `json:"pde"`
 }
 
 type UpdateAttendanceRequest struct {
@@ -45,31 +123,113 @@ type UpdateAttendanceRequest struct {
 }
 
 type UpdateAttendanceResponse struct {
-	MessageType uint8  `json:"mty"`
-	ErrorStatus uint8  `json:"est"`
-	Index       uint32 `json:"index"`
+	MessageType   uint8  `json:"mty"`
+	ErrorStatus   uint8  `json:"est"`
+	ErrorCode     uint8  `json:"ecd,omitempty"`
+	Index         uint32 `json:"index"`
+	DuplicateScan uint8  `json:"dup,omitempty"`
 }
 
+// Attendance is one session. LoginAt and LogoutAt carry the institution timezone
+// so that the stored login and logout times are wall clock times. A nil LogoutAt
+// marks an open session.
 type Attendance struct {
 	StudentId string
-	Date      string
-	Login     string
-	Logout    string
+	Date      time.Time
+	LoginAt   time.Time
+	LogoutAt  *time.Time
+}
+
+type Punch struct {
+	UnitId        string
+	StudentUnitId string
+	StudentId     string
+	Index         uint32
+	ScannedAt     time.Time
+	ReceivedAt    time.Time
+	BusinessDate  time.Time
+}
+
+type DeviceSettings struct {
+	Timezone            string
+	InstitutionTimezone string
+	ScanDebounce        time.Duration
+	HasScanDebounce     bool
+	DayStart            time.Duration
+	Shifts              []Shift
+}
+
+type Shift struct {
+	Name  string
+	Start time.Duration
+	End   time.Duration
 }
 
 type DeviceDatabseInterface interface {
 	CheckDeviceExists(deviceId string) (bool, error)
 	UpdateDeviceStatus(deviceId string, status bool) error
+	GetDeviceEncoding(deviceId string) (string, error)
+	SetDeviceEncoding(deviceId string, encoding string) error
+	GetDeviceProtocolVersion(deviceId string) (uint8, error)
+	SetDeviceProtocolVersion(deviceId string, version uint8) error
+	GetDeviceTopicRoute(deviceId string) (string, string, error)
+	SetDeviceTopicRoute(deviceId string, tenant string, site string) error
+	TouchDevice(deviceId string) error
+	GetDeviceSettings(deviceId string) (*DeviceSettings, error)
 	CheckStudentsExistsInDeletes(deviceId string) (bool, error)
 	GetStudentFromDeletes(deviceId string) (string, error)
 	DeleteStudentFromDeletes(deviceId string, studentId string) error
 	CheckStudentsExistsInInserts(deviceId string) (bool, error)
 	GetStudentFromInserts(deviceId string) (string, string, error)
 	DeleteStudentFromInserts(deviceId string, studentId string) error
+	QuarantineInsert(deviceId string, studentId string, reason string) error
+	GetInsertTransfer(deviceId string, studentId string) (*ChunkTransfer, error)
+	UpdateInsertTransfer(deviceId string, studentId string, chunkSize int, nextChunk int) error
+	GetPendingSyncCounts(deviceId string) (*PendingSync, error)
 	GetStudentId(unitId string, studentUnitId string) (string, error)
-	CheckLoginOrLogout(studentId string, date string) (bool, error)
-	InsertAttendanceLog(attendanceLog *Attendance) error
-	UpdateAttendanceLog(studentId string, date string, logout string) error
+	GetOpenAttendance(studentId string, since time.Time) (*Attendance, bool, error)
+	InsertPunch(punch *Punch) (bool, error)
+	InsertAttendanceLog(attendanceLog *Attendance, punch *Punch) (bool, error)
+	UpdateAttendanceLog(attendanceLog *Attendance, punch *Punch) (bool, error)
+}
+
+// AttendanceWrite is one punch of a write behind batch, Event names the session
+// change it makes and is empty for a punch that does not change any session.
+type AttendanceWrite struct {
+	Punch      *Punch
+	Attendance *Attendance
+	Event      string
+}
+
+// AttendanceBatchDatabaseInterface writes a batch in one transaction and reports
+// for each write whether its punch was new.
+type AttendanceBatchDatabaseInterface interface {
+	WriteAttendance(writes []AttendanceWrite) ([]bool, error)
+}
+
+type AttendanceRebuildInterface interface {
+	GetDeviceSettings(deviceId string) (*DeviceSettings, error)
+	GetPunches(fromDate time.Time, toDate time.Time) ([]Punch, error)
+	GetSessionsOpenAt(fromDate time.Time, since time.Time) ([]Attendance, error)
+	ReplaceAttendance(fromDate time.Time, toDate time.Time, studentIds []string, sessions []Attendance) error
+}
+
+type WebhookDelivery struct {
+	EventId    int64
+	EndpointId int64
+	Url        string
+	Secret     string
+	EventType  string
+	Payload    []byte
+	CreatedAt  time.Time
+	Attempts   int
+}
+
+type WebhookDatabaseInterface interface {
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error)
+	MarkWebhookDelivered(eventId int64, endpointId int64, statusCode int) error
+	MarkWebhookFailed(eventId int64, endpointId int64, statusCode int, reason string, nextAttemptAt *time.Time) error
+	PruneDeliveredWebhookEvents(olderThan time.Duration, limit int) (int64, error)
 }
 
 type DeviceCacheInterface interface {
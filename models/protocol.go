@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Protocol versions of the device messages. Version 1 is the original protocol
+// and is assumed for devices that never sent a version.
+//
+// Version 2 changes the attendance request to carry the scan time as unix seconds
+// in "ts" instead of a device local "tmstmp" string.
+const (
+	ProtocolV1 uint8 = 1
+	ProtocolV2 uint8 = 2
+
+	DefaultProtocolVersion = ProtocolV1
+	LatestProtocolVersion  = ProtocolV2
+)
+
+func IsSupportedProtocolVersion(version uint8) bool {
+	return version >= ProtocolV1 && version <= LatestProtocolVersion
+}
+
+// ParseProtocolVersion accepts the version segment of a topic, "2" or "v2".
+func ParseProtocolVersion(value string) (uint8, bool) {
+	version, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(value), "v"), 10, 8)
+
+	if err != nil || !IsSupportedProtocolVersion(uint8(version)) {
+		return 0, false
+	}
+
+	return uint8(version), true
+}
+
+func FormatProtocolVersion(version uint8) string {
+	return "v" + strconv.Itoa(int(version))
+}
+
+type UpdateAttendanceRequestV2 struct {
+	StudentUnitId uint16 `json:"sid"`
+	Index         uint32 `json:"index"`
+	ScannedAt     int64  `json:"ts"`
+}
@@ -0,0 +1,194 @@
+package processor
+
+import (
+	"errors"
+	"hash/crc32"
+	"log"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+// Chunked insert sync, used when the device sends its maximum chunk size (mcs) with insertsync:
+//
+//	device -> insertsync {"mcs":128}
+//	server -> mty 4 with csz, tch, tln, crc and nxt, the first chunk still missing on the device
+//	server -> mty 8 {"sid":7,"seq":nxt,"dat":"..."}
+//	device -> insertsyncchunkack {"sid":7,"seq":nxt}, answered with the next chunk
+//	device -> insertsyncack {"sid":7} once all chunks match crc
+//
+// Progress is stored with the insert row, so a device that reconnects and repeats
+// insertsync with the same chunk size resumes from the first unacknowledged chunk.
+
+func chunkCount(length int, chunkSize int) int {
+	return (length + chunkSize - 1) / chunkSize
+}
+
+func (p *messageProcessor) startChunkedInsert(client mqtt.Client, route topic.Route, studentId string, requestedChunkSize uint16) {
+	deviceId := route.DeviceId
+
+	chunkSize := min(requestedChunkSize, p.maxChunkSize)
+
+	transfer, err := p.dbRepo.GetInsertTransfer(deviceId, studentId)
+
+	if err != nil {
+		log.Println("error occurred with database while getting the insert transfer, Device Id: ", deviceId, " Error: ", err.Error())
+		response := models.InsertSyncResponse{
+			MessageType: 4,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
+		}
+		p.publish(client, route, response)
+		return
+	}
+
+	totalChunks := chunkCount(len(transfer.FingerprintData), int(chunkSize))
+
+	if totalChunks > 0xFFFF {
+		log.Println("fingerprint template needs too many chunks, Device Id: ", deviceId, " Student Id: ", studentId, " Chunk Size: ", chunkSize)
+		response := models.InsertSyncResponse{
+			MessageType: 4,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeTemplateTooLarge,
+		}
+		p.publish(client, route, response)
+		return
+	}
+
+	nextChunk := transfer.NextChunk
+
+	if transfer.ChunkSize != int(chunkSize) || nextChunk >= totalChunks {
+		nextChunk = 0
+	}
+
+	if transfer.ChunkSize != int(chunkSize) || nextChunk != transfer.NextChunk {
+		if err := p.dbRepo.UpdateInsertTransfer(deviceId, studentId, int(chunkSize), nextChunk); err != nil {
+			log.Println("error occurred with database while starting the insert transfer, Device Id: ", deviceId, " Error: ", err.Error())
+			response := models.InsertSyncResponse{
+				MessageType: 4,
+				ErrorStatus: errorStatus(err),
+				ErrorCode:   errorCode(err),
+			}
+			p.publish(client, route, response)
+			return
+		}
+	}
+
+	studentIdInt, _ := strconv.Atoi(studentId)
+
+	response := models.InsertSyncResponse{
+		MessageType:    4,
+		ErrorStatus:    0,
+		StudentsEmpty:  0,
+		StudentId:      uint16(studentIdInt),
+		ChunkSize:      chunkSize,
+		TotalChunks:    uint16(totalChunks),
+		TemplateLength: uint32(len(transfer.FingerprintData)),
+		Checksum:       crc32.ChecksumIEEE([]byte(transfer.FingerprintData)),
+		NextChunk:      uint16(nextChunk),
+	}
+
+	p.publish(client, route, response)
+
+	p.publishChunk(client, route, uint16(studentIdInt), transfer.FingerprintData, int(chunkSize), nextChunk)
+}
+
+func (p *messageProcessor) publishChunk(client mqtt.Client, route topic.Route, studentId uint16, data string, chunkSize int, sequence int) {
+	start := sequence * chunkSize
+	end := min(start+chunkSize, len(data))
+
+	chunk := models.InsertSyncChunk{
+		MessageType: 8,
+		ErrorStatus: 0,
+		StudentId:   studentId,
+		Sequence:    uint16(sequence),
+		Data:        data[start:end],
+	}
+
+	p.publish(client, route, chunk)
+}
+
+func (p *messageProcessor) processDeviceInsertSyncChunkAckRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
+	req := new(models.InsertSyncChunkAckRequest)
+
+	if err := p.decode(message, req); err != nil {
+		log.Println("error occurred while decoding json insert sync chunk ack message, Device Id: ", deviceId, " Error: ", err.Error())
+		response := models.InsertSyncChunk{
+			MessageType: 8,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidPayload,
+		}
+		p.publish(client, route, response)
+		return
+	}
+
+	studentId := strconv.Itoa(int(req.StudentId))
+
+	transfer, err := p.dbRepo.GetInsertTransfer(deviceId, studentId)
+
+	if err != nil || transfer.ChunkSize == 0 {
+		code := models.ErrorCodeInvalidChunk
+
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Println("error occurred with database while getting the insert transfer, Device Id: ", deviceId, " Error: ", err.Error())
+			code = errorCode(err)
+		} else {
+			log.Println("chunk ack without an insert transfer, Device Id: ", deviceId, " Student Id: ", studentId)
+		}
+		response := models.InsertSyncChunk{
+			MessageType: 8,
+			ErrorStatus: models.ErrorStatusFor(code),
+			ErrorCode:   code,
+			StudentId:   req.StudentId,
+			Sequence:    req.Sequence,
+		}
+		p.publish(client, route, response)
+		return
+	}
+
+	totalChunks := chunkCount(len(transfer.FingerprintData), transfer.ChunkSize)
+
+	sequence := int(req.Sequence)
+
+	if sequence >= totalChunks || sequence > transfer.NextChunk {
+		log.Println("chunk ack out of range, Device Id: ", deviceId, " Student Id: ", studentId, " Sequence: ", sequence, " Expected: ", transfer.NextChunk)
+		response := models.InsertSyncChunk{
+			MessageType: 8,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidChunk,
+			StudentId:   req.StudentId,
+			Sequence:    req.Sequence,
+		}
+		p.publish(client, route, response)
+		return
+	}
+
+	//a repeated ack of an older chunk only resends the chunk the device is missing
+	nextChunk := transfer.NextChunk
+
+	if sequence == transfer.NextChunk {
+		nextChunk = sequence + 1
+
+		if err := p.dbRepo.UpdateInsertTransfer(deviceId, studentId, transfer.ChunkSize, nextChunk); err != nil {
+			log.Println("error occurred with database while updating the insert transfer, Device Id: ", deviceId, " Error: ", err.Error())
+			response := models.InsertSyncChunk{
+				MessageType: 8,
+				ErrorStatus: errorStatus(err),
+				ErrorCode:   errorCode(err),
+				StudentId:   req.StudentId,
+				Sequence:    req.Sequence,
+			}
+			p.publish(client, route, response)
+			return
+		}
+	}
+
+	if nextChunk < totalChunks {
+		p.publishChunk(client, route, req.StudentId, transfer.FingerprintData, transfer.ChunkSize, nextChunk)
+	}
+}
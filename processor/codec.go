@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"log"
+
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+// Devices pick the payload encoding of their responses in the connection request,
+// either by sending "enc" or by suffixing the message type of the topic with ".cbor"
+// or ".json". The encoding is stored with the device until its next connection. A
+// suffix on any other message only picks the encoding of that response. Requests are
+// always decoded with the encoding they were sent in.
+
+func (p *messageProcessor) decode(message []byte, v any) error {
+	return codec.Detect(message).Unmarshal(message, v)
+}
+
+func (p *messageProcessor) deviceCodec(deviceId string) codec.Codec {
+	c, err := p.codecs.Load(deviceId, func() (codec.Codec, error) {
+		encoding, err := p.dbRepo.GetDeviceEncoding(deviceId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if c, ok := codec.ByName(encoding); ok {
+			return c, nil
+		}
+
+		return codec.Json, nil
+	})
+
+	if err != nil {
+		log.Println("error occurred with database while getting the device encoding, Device Id: ", deviceId, " Error: ", err.Error())
+		return codec.Json
+	}
+
+	return c
+}
+
+// responseCodec answers in the encoding of the topic suffix when the message has
+// one, and in the encoding of the device otherwise.
+func (p *messageProcessor) responseCodec(route topic.Route) codec.Codec {
+	if c, ok := codec.ByName(route.Encoding); ok {
+		return c
+	}
+
+	return p.deviceCodec(route.DeviceId)
+}
+
+// registerCodec stores the encoding asked for by a connection request, "enc" takes
+// precedence over the topic suffix. A connection asking for neither resets the device
+// to JSON, so an encoding does not outlive the firmware that chose it.
+func (p *messageProcessor) registerCodec(route topic.Route, encoding string) error {
+	c := codec.Json
+
+	if suffix, ok := codec.ByName(route.Encoding); ok {
+		c = suffix
+	}
+
+	if encoding != "" {
+		if requested, ok := codec.ByName(encoding); ok {
+			c = requested
+		} else {
+			log.Println("unsupported payload encoding in the connection request, Device Id: ", route.DeviceId, " Encoding: ", encoding)
+		}
+	}
+
+	if current, ok := p.codecs.Get(route.DeviceId); ok && current == c {
+		return nil
+	}
+
+	if err := p.dbRepo.SetDeviceEncoding(route.DeviceId, c.Name()); err != nil {
+		return err
+	}
+
+	p.codecs.Set(route.DeviceId, c)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+const (
+	RejectMalformedTopic     = "malformed_topic"
+	RejectUnknownType        = "unknown_type"
+	RejectUnsupportedVersion = "unsupported_version"
+	RejectUnknownDevice      = "unknown_device"
+
+	payloadExcerptLength = 64
+)
+
+// reject counts, logs and dead letters a message that can not be processed. The
+// device is told when its id is known, devices on a typo'd topic otherwise wait
+// for a response that never comes.
+func (p *messageProcessor) reject(client mqtt.Client, message mqtt.Message, deviceId string, messageType string, reason string) {
+	metrics.RejectedMessages.Add(reason, 1)
+
+	log.Printf("rejected message, Reason: %v Topic: %q Device Id: %q Payload: %q", reason, message.Topic(), deviceId, excerpt(message.Payload()))
+
+	if p.deadLetterTopic != "" {
+		letter, err := json.Marshal(models.DeadLetter{
+			Topic:      message.Topic(),
+			DeviceId:   deviceId,
+			Reason:     reason,
+			Payload:    message.Payload(),
+			ReceivedAt: time.Now(),
+		})
+
+		if err != nil {
+			log.Println("failed to encode the dead letter, Error: ", err.Error())
+		} else {
+			client.Publish(p.deadLetterTopic, 1, false, letter)
+		}
+	}
+
+	//unregistered devices are not answered, anyone can publish on their topics
+	if deviceId == "" || reason == RejectUnknownDevice {
+		return
+	}
+
+	route := topic.Route{DeviceId: deviceId}
+
+	if stored, ok := p.routes.Load(deviceId); ok {
+		route = stored.(topic.Route)
+	}
+
+	route.Type = messageType
+
+	response := models.UnsupportedMessageResponse{
+		MessageType: models.MessageTypeUnsupported,
+		ErrorStatus: models.ErrorStatusFor(models.ErrorCodeUnsupportedType),
+		ErrorCode:   models.ErrorCodeUnsupportedType,
+		Type:        messageType,
+	}
+
+	if reason == RejectUnsupportedVersion {
+		response.ErrorCode = models.ErrorCodeUnsupportedVersion
+	}
+
+	p.publishWith(client, route, p.cachedCodec(deviceId, nil, message.Payload()), response)
+}
+
+func excerpt(payload []byte) string {
+	if len(payload) > payloadExcerptLength {
+		return string(payload[:payloadExcerptLength]) + "..."
+	}
+	return string(payload)
+}
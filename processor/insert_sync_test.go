@@ -0,0 +1,57 @@
+package processor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/vithsutra/biometric-project-message-processor/fingerprint"
+	"github.com/vithsutra/biometric-project-message-processor/repository"
+)
+
+func TestNextValidInsertQuarantinesInvalidTemplates(t *testing.T) {
+	fixture := new(repository.MemoryFixture)
+
+	//the queue is served in student order, the first two templates are invalid
+	err := json.Unmarshal([]byte(`{
+		"devices": [{"unit_id": "vs23cg003"}],
+		"inserts": [
+			{"unit_id": "vs23cg003", "student_unit_id": "1", "fingerprint_data": "not hex"},
+			{"unit_id": "vs23cg003", "student_unit_id": "2", "fingerprint_data": "58585858"},
+			{"unit_id": "vs23cg003", "student_unit_id": "3", "fingerprint_data": "464d5201"}
+		]
+	}`), fixture)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.NewMemoryRepository(fixture)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := fingerprint.NewValidator(fingerprint.EncodingHex, 4, hex.EncodeToString([]byte("FMR")), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &messageProcessor{dbRepo: repo, templateValidator: validator}
+
+	studentId, fingerprintData, exists, err := p.nextValidInsert("vs23cg003")
+
+	if err != nil || !exists || studentId != "3" || fingerprintData != "464d5201" {
+		t.Fatalf("got student %q %q %v %v, want the valid template of student 3", studentId, fingerprintData, exists, err)
+	}
+
+	//the quarantined templates stay out of the queue
+	if err := repo.DeleteStudentFromInserts("vs23cg003", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, exists, err := p.nextValidInsert("vs23cg003"); exists || err != nil {
+		t.Errorf("got exists %v and error %v after the valid template was synced, want an empty queue", exists, err)
+	}
+}
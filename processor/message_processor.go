@@ -1,65 +1,194 @@
 package processor
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/attendance"
+	"github.com/vithsutra/biometric-project-message-processor/cache"
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+	"github.com/vithsutra/biometric-project-message-processor/fingerprint"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
 	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/ratelimit"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
 )
 
+const lastSeenInterval = 30 * time.Second
+
+// the last seen time and route of a device are dropped after deviceIdleTimeout without
+// a message, checked at most once per sweepInterval
+const (
+	deviceIdleTimeout = 24 * time.Hour
+	sweepInterval     = time.Minute
+)
+
+// the encoding, protocol version and topic route of the devices are kept for deviceStateTtl, after
+// that they are read from the database again
+const (
+	deviceStateTtl        = time.Hour
+	deviceStateMaxEntries = 100000
+)
+
+// studentLock counts the scans holding or waiting for the lock of a student.
+type studentLock struct {
+	mu      sync.Mutex
+	holders int
+}
+
 type messageProcessor struct {
-	messageQueue     chan mqtt.Message
-	mqttClient       mqtt.Client
-	dbRepo           models.DeviceDatabseInterface
-	workerNodesCount uint32
+	messageQueue       chan mqtt.Message
+	mqttClient         mqtt.Client
+	dbRepo             models.DeviceDatabseInterface
+	requestTopic       *topic.Template
+	responseTopic      *topic.Template
+	debounce           time.Duration
+	maxSession         time.Duration
+	eventSink          models.EventSink
+	notifyOnConnect    bool
+	templateValidator  *fingerprint.Validator
+	maxChunkSize       uint16
+	workerNodesCount   uint32
+	locations          sync.Map
+	lastSeen           sync.Map
+	routes             sync.Map
+	codecs             *cache.Cache[string, codec.Codec]
+	versions           *cache.Cache[string, uint8]
+	topicRoutes        *cache.Cache[string, topic.Route]
+	deprecatedVersions map[uint8]bool
+	limiter            *ratelimit.Limiter
+	deadLetterTopic    string
+	studentLocksMu     sync.Mutex
+	studentLocks       map[string]*studentLock
+	sweepMu            sync.Mutex
+	sweptAt            time.Time
 }
 
 func NewMessageProcessor(
 	mqttClient mqtt.Client,
 	dbRepo models.DeviceDatabseInterface,
+	requestTopic *topic.Template,
+	responseTopic *topic.Template,
+	debounce time.Duration,
+	maxSession time.Duration,
+	eventSink models.EventSink,
+	notifyOnConnect bool,
+	templateValidator *fingerprint.Validator,
+	maxChunkSize uint16,
+	deprecatedVersions []uint8,
+	limiter *ratelimit.Limiter,
+	deadLetterTopic string,
 	workerNodesCount uint32,
 	queueBufferSize uint32,
 ) *messageProcessor {
+	deprecated := make(map[uint8]bool, len(deprecatedVersions))
+
+	for _, version := range deprecatedVersions {
+		deprecated[version] = true
+	}
+
 	return &messageProcessor{
-		messageQueue:     make(chan mqtt.Message, queueBufferSize),
-		mqttClient:       mqttClient,
-		dbRepo:           dbRepo,
-		workerNodesCount: workerNodesCount,
+		messageQueue:       make(chan mqtt.Message, queueBufferSize),
+		mqttClient:         mqttClient,
+		dbRepo:             dbRepo,
+		requestTopic:       requestTopic,
+		responseTopic:      responseTopic,
+		debounce:           debounce,
+		maxSession:         maxSession,
+		eventSink:          eventSink,
+		notifyOnConnect:    notifyOnConnect,
+		templateValidator:  templateValidator,
+		maxChunkSize:       maxChunkSize,
+		workerNodesCount:   workerNodesCount,
+		deprecatedVersions: deprecated,
+		limiter:            limiter,
+		deadLetterTopic:    deadLetterTopic,
+		studentLocks:       make(map[string]*studentLock),
+		codecs:             cache.New[string, codec.Codec]("device_codec", deviceStateTtl, deviceStateMaxEntries),
+		versions:           cache.New[string, uint8]("device_protocol_version", deviceStateTtl, deviceStateMaxEntries),
+		topicRoutes:        cache.New[string, topic.Route]("device_topic_route", deviceStateTtl, deviceStateMaxEntries),
 	}
 }
 
 func (p *messageProcessor) processMessage(c mqtt.Client, message mqtt.Message) {
-	topicArr := strings.Split(message.Topic(), "/")
-
-	if len(topicArr) > 3 {
-		deviceId := topicArr[0]
-		deviceId = strings.Trim(deviceId, " ")
-		messageType := topicArr[2]
-		messageType = strings.Trim(messageType, " ")
-		switch messageType {
-		case "connection":
-			p.processDeviceConnectionRequest(c, deviceId, message.Payload())
-		case "disconnection":
-			p.processDeviceDisconnectionRequest(c, deviceId, message.Payload())
-		case "deletesync":
-			p.processDeviceDeleteSyncRequest(c, deviceId, message.Payload())
-		case "deletesyncack":
-			p.processDeviceDeleteSyncAckRequest(c, deviceId, message.Payload())
-		case "insertsync":
-			p.processDeviceInsertSyncRequest(c, deviceId, message.Payload())
-		case "insertsyncack":
-			p.processDeviceInsertSyncAckRequest(c, deviceId, message.Payload())
-		case "attendance":
-			p.processAttendanceRequest(c, deviceId, message.Payload())
-		}
+	route, ok := p.requestTopic.Parse(message.Topic())
+
+	if !ok {
+		deviceId, _ := p.requestTopic.DeviceId(message.Topic())
+		p.reject(c, message, deviceId, "", RejectMalformedTopic)
+		return
+	}
+
+	messageType, typeCodec := codec.SplitType(route.Type)
+
+	route.Type = messageType
+
+	//device ids come from topics, only registered devices are tracked
+	registered, err := p.dbRepo.CheckDeviceExists(route.DeviceId)
+
+	if err != nil {
+		log.Println("error occurred with database while checking device exists, Device Id: ", route.DeviceId, " Error: ", err.Error())
+	}
+
+	if err == nil && !registered && messageType != "connection" {
+		p.reject(c, message, route.DeviceId, messageType, RejectUnknownDevice)
+		return
+	}
+
+	if registered {
+		p.touchDevice(route.DeviceId)
+
+		p.routes.Store(route.DeviceId, route)
+	}
+
+	//the suffix only picks the encoding of this response, the connection request sets the encoding of the device
+	if typeCodec != nil {
+		route.Encoding = typeCodec.Name()
+	}
+
+	//the connection handler only runs for registered devices, the others are answered here
+	if messageType == "connection" && !registered {
+		p.refuseConnection(c, route, err)
+		metrics.MessagesProcessed.Add(route.Type, 1)
+		return
 	}
+
+	version, ok := p.protocolVersion(route)
+
+	if !ok {
+		p.reject(c, message, route.DeviceId, route.Type, RejectUnsupportedVersion)
+		return
+	}
+
+	if registered {
+		p.recordProtocolVersion(route.DeviceId, version)
+	}
+
+	handler, ok := protocolHandlers[version][route.Type]
+
+	if !ok {
+		p.reject(c, message, route.DeviceId, route.Type, RejectUnknownType)
+		return
+	}
+
+	handler(p, c, route, message.Payload())
+	metrics.MessagesProcessed.Add(route.Type, 1)
 }
 
 func (p *messageProcessor) Start() {
+	metrics.PublishQueue("message_queue", func() metrics.QueueStats {
+		return metrics.QueueStats{
+			Length:   len(p.messageQueue),
+			Capacity: cap(p.messageQueue),
+		}
+	})
+
 	for i := 0; i < int(p.workerNodesCount); i++ {
 		go func() {
 			for m := range p.messageQueue {
@@ -69,63 +198,288 @@ func (p *messageProcessor) Start() {
 	}
 }
 
+// Push blocks while the queue is full, which also holds back the mqtt client, each
+// time that happens is counted as a sign that the workers can not keep up. Messages
+// over the rate limit of their device never reach the queue.
 func (p *messageProcessor) Push(message mqtt.Message) {
-	p.messageQueue <- message
+	if !p.allow(message) {
+		return
+	}
+
+	select {
+	case p.messageQueue <- message:
+	default:
+		metrics.MessageQueueFull.Add(1)
+		p.messageQueue <- message
+	}
 }
 
-func (p *messageProcessor) processDeviceConnectionRequest(client mqtt.Client, deviceId string, message []byte) {
-	deviceExists, err := p.dbRepo.CheckDeviceExists(deviceId)
+// Process handles a message on the calling goroutine, replays use it to keep the
+// order of the responses deterministic.
+func (p *messageProcessor) Process(message mqtt.Message) {
+	p.processMessage(p.mqttClient, message)
+}
+
+func (p *messageProcessor) Subscription() string {
+	return p.requestTopic.Subscription()
+}
+
+// touchDevice records the last seen time of a device, at most once per lastSeenInterval.
+func (p *messageProcessor) touchDevice(deviceId string) {
+	now := time.Now()
+
+	if last, ok := p.lastSeen.Load(deviceId); ok && now.Sub(last.(time.Time)) < lastSeenInterval {
+		return
+	}
+
+	p.lastSeen.Store(deviceId, now)
+
+	if err := p.dbRepo.TouchDevice(deviceId); err != nil {
+		log.Println("error occurred with database while updating the device last seen time, Device Id: ", deviceId, " Error: ", err.Error())
+	}
+
+	p.sweepDevices(now)
+}
+
+// sweepDevices drops the last seen time, route and deprecated protocol flag of
+// devices that sent nothing for deviceIdleTimeout, such as devices removed from the
+// registry, so the maps only hold the devices in use.
+func (p *messageProcessor) sweepDevices(now time.Time) {
+	p.sweepMu.Lock()
+
+	if now.Sub(p.sweptAt) < sweepInterval {
+		p.sweepMu.Unlock()
+		return
+	}
+
+	p.sweptAt = now
+	p.sweepMu.Unlock()
+
+	p.lastSeen.Range(func(deviceId, lastSeen any) bool {
+		//a device touched meanwhile keeps its entries
+		if now.Sub(lastSeen.(time.Time)) > deviceIdleTimeout && p.lastSeen.CompareAndDelete(deviceId, lastSeen) {
+			p.routes.Delete(deviceId)
+			metrics.DeprecatedProtocolDevices.Delete(deviceId.(string))
+		}
+		return true
+	})
+}
+
+func (p *messageProcessor) loadLocation(name string) (*time.Location, error) {
+	if location, ok := p.locations.Load(name); ok {
+		return location.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.locations.Store(name, location)
+
+	return location, nil
+}
+
+func (p *messageProcessor) emit(eventType string, deviceId string, data any) {
+	event := &models.Event{
+		Id:         uuid.NewString(),
+		Type:       eventType,
+		Version:    models.EventSchemaVersions[eventType],
+		Source:     "biometric-message-producer",
+		DeviceId:   deviceId,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+
+	if err := p.eventSink.Publish(event); err != nil {
+		log.Println("failed to publish the event to the event sink, Event Type: ", eventType, " Device Id: ", deviceId, " Error: ", err.Error())
+	}
+}
+
+func (p *messageProcessor) emitAttendance(punch *models.Punch, direction string) {
+	eventType := models.EventAttendanceLogin
+
+	if direction == "logout" {
+		eventType = models.EventAttendanceLogout
+	}
+
+	p.emit(eventType, punch.UnitId, models.AttendanceRecordedData{
+		StudentId:     punch.StudentId,
+		StudentUnitId: punch.StudentUnitId,
+		Index:         punch.Index,
+		Direction:     direction,
+		Date:          punch.BusinessDate.Format("2006-01-02"),
+		ScannedAt:     punch.ScannedAt,
+	})
+}
+
+func (p *messageProcessor) publish(client mqtt.Client, route topic.Route, message any) {
+	p.publishWith(client, route, p.responseCodec(route), message)
+}
+
+func (p *messageProcessor) publishWith(client mqtt.Client, route topic.Route, c codec.Codec, message any) {
+	responseTopic, err := p.responseTopic.Format(route)
+
+	if err != nil {
+		log.Println("failed to build the response topic, Device Id: ", route.DeviceId, " Error: ", err.Error())
+		return
+	}
+
+	payload, err := c.Marshal(message)
 
 	if err != nil {
-		log.Println("error occurred with database while checking device exists, Device Id: ", deviceId, " Error: ", err.Error())
+		log.Println("failed to encode the response, Device Id: ", route.DeviceId, " Error: ", err.Error())
+		return
+	}
+
+	client.Publish(responseTopic, 1, false, payload)
+}
+
+// errorCode maps a database error, while the database is unavailable devices are
+// told to retry later instead of treating the request as failed.
+func errorCode(err error) uint8 {
+	if errors.Is(err, models.ErrUnavailable) {
+		return models.ErrorCodeUnavailable
+	}
+	return models.ErrorCodeDatabase
+}
+
+func errorStatus(err error) uint8 {
+	return models.ErrorStatusFor(errorCode(err))
+}
+
+// refuseConnection answers the connection request of a device that is not registered,
+// or whose registration could not be checked, err is the error of the registry check.
+func (p *messageProcessor) refuseConnection(client mqtt.Client, route topic.Route, err error) {
+	if err != nil {
 		response := models.ConnectionUpdateResponse{
 			MessageType: 1,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
-	if !deviceExists {
-		log.Println("connection request from the invalid device, Device Id: ", deviceId)
+	log.Println("connection request from the invalid device, Device Id: ", route.DeviceId)
+
+	response := models.ConnectionUpdateResponse{
+		MessageType: 1,
+		ErrorStatus: 1,
+		ErrorCode:   models.ErrorCodeUnknownDevice,
+	}
 
+	p.publish(client, route, response)
+}
+
+// processDeviceConnectionRequest is only called for registered devices, processMessage
+// checks the registry once and refuses the connection of any other device.
+func (p *messageProcessor) processDeviceConnectionRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
+	//the connection request may be empty, older firmware sends no registration fields
+	req := new(models.ConnectionRequest)
+
+	if len(message) > 0 {
+		if err := p.decode(message, req); err != nil {
+			log.Println("ignoring invalid registration fields in the connection request, Device Id: ", deviceId, " Error: ", err.Error())
+		}
+	}
+
+	if err := p.registerCodec(route, req.Encoding); err != nil {
+		log.Println("error occurred with database while storing the device encoding, Device Id: ", deviceId, " Error: ", err.Error())
 		response := models.ConnectionUpdateResponse{
 			MessageType: 1,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
+		p.publish(client, route, response)
+		return
+	}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+	if err := p.registerTopicRoute(route); err != nil {
+		log.Println("error occurred with database while storing the device topic route, Device Id: ", deviceId, " Error: ", err.Error())
+		response := models.ConnectionUpdateResponse{
+			MessageType: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
+		}
+		p.publish(client, route, response)
 		return
 	}
 
+	//a connection without a version resets the device to the default version
+	version := req.Version
+
+	if version == 0 {
+		version = models.DefaultProtocolVersion
+	}
+
+	if current, ok := p.versions.Get(deviceId); !ok || current != version {
+		if !models.IsSupportedProtocolVersion(version) {
+			log.Println("connection request with an unsupported protocol version, Device Id: ", deviceId, " Version: ", req.Version)
+			response := models.ConnectionUpdateResponse{
+				MessageType: 1,
+				ErrorStatus: 1,
+				ErrorCode:   models.ErrorCodeUnsupportedVersion,
+			}
+			p.publish(client, route, response)
+			return
+		}
+
+		if err := p.dbRepo.SetDeviceProtocolVersion(deviceId, version); err != nil {
+			log.Println("error occurred with database while storing the device protocol version, Device Id: ", deviceId, " Error: ", err.Error())
+			response := models.ConnectionUpdateResponse{
+				MessageType: 1,
+				ErrorStatus: errorStatus(err),
+				ErrorCode:   errorCode(err),
+			}
+			p.publish(client, route, response)
+			return
+		}
+
+		p.versions.Set(deviceId, version)
+	}
+
 	if err := p.dbRepo.UpdateDeviceStatus(deviceId, true); err != nil {
 		log.Println("error occurred with database while updating the connection status, Device Id: ", deviceId, " Error: ", err.Error())
 		response := models.ConnectionUpdateResponse{
 			MessageType: 1,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
+	p.emit(models.EventDeviceConnected, deviceId, models.DeviceStatusData{Online: true})
+
 	response := models.ConnectionUpdateResponse{
 		MessageType: 1,
 		ErrorStatus: 0,
+		Version:     version,
+	}
+	p.publish(client, route, response)
+
+	if p.notifyOnConnect {
+		p.NotifySyncAvailable(deviceId)
 	}
-	responseJson, _ := json.Marshal(response)
-	client.Publish(deviceId, 1, false, responseJson)
 }
 
-func (p *messageProcessor) processDeviceDisconnectionRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processDeviceDisconnectionRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
 	if err := p.dbRepo.UpdateDeviceStatus(deviceId, false); err != nil {
 		log.Println("error occurred with database while updating the disconnection status, Device Id: ", deviceId, " Error: ", err.Error())
+		return
 	}
+
+	p.emit(models.EventDeviceDisconnected, deviceId, models.DeviceStatusData{Online: false})
 }
 
-func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
 
 	exists, err := p.dbRepo.CheckStudentsExistsInDeletes(deviceId)
 
@@ -134,13 +488,13 @@ func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, de
 
 		response := models.DeleteSyncResponse{
 			MessageType:   2,
-			ErrorStatus:   1,
+			ErrorStatus:   errorStatus(err),
+			ErrorCode:     errorCode(err),
 			StudentsEmpty: 0,
 			StudentId:     0,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -152,8 +506,7 @@ func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, de
 			StudentId:     0,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -164,13 +517,13 @@ func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, de
 
 		response := models.DeleteSyncResponse{
 			MessageType:   2,
-			ErrorStatus:   1,
+			ErrorStatus:   errorStatus(err),
+			ErrorCode:     errorCode(err),
 			StudentsEmpty: 0,
 			StudentId:     0,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -183,25 +536,25 @@ func (p *messageProcessor) processDeviceDeleteSyncRequest(client mqtt.Client, de
 		StudentId:     uint16(studentIdInt),
 	}
 
-	responseJson, _ := json.Marshal(response)
-	client.Publish(deviceId, 1, false, responseJson)
+	p.publish(client, route, response)
 
 }
 
-func (p *messageProcessor) processDeviceDeleteSyncAckRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processDeviceDeleteSyncAckRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
 
 	req := new(models.DeleteSyncAckRequest)
 
-	if err := json.Unmarshal(message, req); err != nil {
+	if err := p.decode(message, req); err != nil {
 		log.Println("invalid json format in the delete sync ack request, Device Id: ", deviceId, " Error: ", err.Error())
 
 		response := models.DeleteSyncAckResponse{
 			MessageType: 3,
 			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidPayload,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -209,34 +562,48 @@ func (p *messageProcessor) processDeviceDeleteSyncAckRequest(client mqtt.Client,
 		log.Println("error occurred with database while deleting the student from deletes, Device Id: ", deviceId, " Error: ", err.Error())
 		response := models.DeleteSyncAckResponse{
 			MessageType: 3,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
+	p.emit(models.EventEnrollmentRemoved, deviceId, models.TemplateSyncedData{
+		StudentUnitId: strconv.Itoa(int(req.StudentId)),
+		Operation:     "delete",
+	})
+
 	response := models.DeleteSyncAckResponse{
 		MessageType: 3,
 		ErrorStatus: 0,
 	}
-	responseJson, _ := json.Marshal(response)
-	client.Publish(deviceId, 1, false, responseJson)
+	p.publish(client, route, response)
 
 }
 
-func (p *messageProcessor) processDeviceInsertSyncRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processDeviceInsertSyncRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
+	req := new(models.InsertSyncRequest)
+
+	//older firmware sends no payload with insertsync
+	if len(message) > 0 {
+		if err := p.decode(message, req); err != nil {
+			log.Println("ignoring invalid json in the insert sync request, Device Id: ", deviceId, " Error: ", err.Error())
+		}
+	}
 
-	exists, err := p.dbRepo.CheckStudentsExistsInInserts(deviceId)
+	studentId, fingerprintData, exists, err := p.nextValidInsert(deviceId)
 
 	if err != nil {
-		log.Println("error occurred with database while checking student exists in inserts, Device Id: ", deviceId, " Error: ", err.Error())
+		log.Println("error occurred with database while getting student from inserts, Device Id: ", deviceId, " Error: ", err.Error())
 		response := models.InsertSyncResponse{
 			MessageType: 4,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -246,21 +613,12 @@ func (p *messageProcessor) processDeviceInsertSyncRequest(client mqtt.Client, de
 			ErrorStatus:   0,
 			StudentsEmpty: 1,
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
-	studentId, fingerprintData, err := p.dbRepo.GetStudentFromInserts(deviceId)
-
-	if err != nil {
-		log.Println("error occurred with database while getting student from inserts, Device Id: ", deviceId, " Error: ", err.Error())
-		response := models.InsertSyncResponse{
-			MessageType: 4,
-			ErrorStatus: 1,
-		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+	if req.MaxChunkSize > 0 {
+		p.startChunkedInsert(client, route, studentId, req.MaxChunkSize)
 		return
 	}
 
@@ -274,21 +632,55 @@ func (p *messageProcessor) processDeviceInsertSyncRequest(client mqtt.Client, de
 		FingerPrintData: fingerprintData,
 	}
 
-	responseJson, _ := json.Marshal(response)
-	client.Publish(deviceId, 1, false, responseJson)
+	p.publish(client, route, response)
+}
+
+// nextValidInsert returns the next queued template of a device that passes validation.
+// Invalid templates and templates that can not be decrypted are quarantined, so that
+// the device never receives them and the rest of its queue is still synced.
+func (p *messageProcessor) nextValidInsert(deviceId string) (string, string, bool, error) {
+	for {
+		exists, err := p.dbRepo.CheckStudentsExistsInInserts(deviceId)
+
+		if err != nil || !exists {
+			return "", "", false, err
+		}
+
+		studentId, fingerprintData, err := p.dbRepo.GetStudentFromInserts(deviceId)
+
+		validationErr := err
+
+		if err == nil {
+			validationErr = p.templateValidator.Validate(fingerprintData)
+
+			if validationErr == nil {
+				return studentId, fingerprintData, true, nil
+			}
+		} else if !errors.Is(err, models.ErrTemplateUndecryptable) {
+			return "", "", false, err
+		}
+
+		log.Println("quarantining invalid fingerprint template, Device Id: ", deviceId, " Student Id: ", studentId, " Reason: ", validationErr.Error())
+
+		if err := p.dbRepo.QuarantineInsert(deviceId, studentId, validationErr.Error()); err != nil {
+			return "", "", false, err
+		}
+	}
 }
 
-func (p *messageProcessor) processDeviceInsertSyncAckRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processDeviceInsertSyncAckRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
 	req := new(models.InsertSyncAckRequest)
 
-	if err := json.Unmarshal(message, req); err != nil {
+	if err := p.decode(message, req); err != nil {
 		log.Println("error occurred while decoding json insert sync ack message, Device Id: ", deviceId, " Error: ", err.Error())
 		response := models.InsertSyncAckResponse{
 			MessageType: 5,
 			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidPayload,
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
@@ -297,133 +689,292 @@ func (p *messageProcessor) processDeviceInsertSyncAckRequest(client mqtt.Client,
 
 		response := models.InsertSyncAckResponse{
 			MessageType: 5,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
+	p.emit(models.EventEnrollmentSynced, deviceId, models.TemplateSyncedData{
+		StudentUnitId: strconv.Itoa(int(req.StudentId)),
+		Operation:     "insert",
+	})
+
 	response := models.InsertSyncAckResponse{
 		MessageType: 5,
 		ErrorStatus: 0,
 	}
 
-	responseJson, _ := json.Marshal(response)
-	client.Publish(deviceId, 1, false, responseJson)
+	p.publish(client, route, response)
 }
 
-func (p *messageProcessor) processAttendanceRequest(client mqtt.Client, deviceId string, message []byte) {
+func (p *messageProcessor) processAttendanceRequest(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
+	receivedAt := time.Now()
 
 	req := new(models.UpdateAttendanceRequest)
 
-	if err := json.Unmarshal(message, req); err != nil {
+	if err := p.decode(message, req); err != nil {
 		log.Println("error occurred while decoding the json in update attendance request, DeviceId:", deviceId, " Error: ", err.Error())
 
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
 			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidPayload,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
+	//device rtc runs in the device timezone, the attendance date belongs to the institution timezone
+	p.recordAttendance(client, route, req, receivedAt, func(deviceLocation *time.Location) (time.Time, error) {
+		return time.ParseInLocation("2006-01-02T15:04:05", req.TimeStamp, deviceLocation)
+	})
+}
+
+// lockStudent serializes the scans of a student from reading the open session to
+// writing the punch, a write held back by the write behind buffer would otherwise
+// be missed by the next scan of the same student. Scans of different students never
+// wait for each other, and a lock is dropped once nobody holds or waits for it.
+func (p *messageProcessor) lockStudent(studentId string) func() {
+	p.studentLocksMu.Lock()
+
+	lock, ok := p.studentLocks[studentId]
+
+	if !ok {
+		lock = new(studentLock)
+		p.studentLocks[studentId] = lock
+	}
+
+	lock.holders++
+
+	p.studentLocksMu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		p.studentLocksMu.Lock()
+		defer p.studentLocksMu.Unlock()
+
+		if lock.holders--; lock.holders == 0 {
+			delete(p.studentLocks, studentId)
+		}
+	}
+}
+
+// recordAttendance is shared by the attendance handlers of every protocol version,
+// scannedAt converts the version specific timestamp using the device timezone.
+func (p *messageProcessor) recordAttendance(client mqtt.Client, route topic.Route, req *models.UpdateAttendanceRequest, receivedAt time.Time, scannedAt func(*time.Location) (time.Time, error)) {
+	deviceId := route.DeviceId
+
 	studentId, err := p.dbRepo.GetStudentId(deviceId, strconv.Itoa(int(req.StudentUnitId)))
 
 	if err != nil {
-		log.Println("error occurred while updating the student attendance, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
+		code := errorCode(err)
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Println("attendance from a student unit id that is not enrolled, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId)
+			code = models.ErrorCodeUnknownStudent
+		} else {
+			log.Println("error occurred while updating the student attendance, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
+		}
+
+		response := models.UpdateAttendanceResponse{
+			MessageType: 6,
+			ErrorStatus: models.ErrorStatusFor(code),
+			ErrorCode:   code,
+		}
+
+		p.publish(client, route, response)
+		return
+	}
+
+	settings, err := p.dbRepo.GetDeviceSettings(deviceId)
+
+	if err != nil {
+		log.Println("error occurred with database while getting the device settings, DeviceId: ", deviceId, " Error: ", err.Error())
+		response := models.UpdateAttendanceResponse{
+			MessageType: 6,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
+		}
+
+		p.publish(client, route, response)
+		return
+	}
+
+	deviceLocation, err := p.loadLocation(settings.Timezone)
+
+	if err != nil {
+		log.Println("invalid device timezone, DeviceId: ", deviceId, " Timezone: ", settings.Timezone, " Error: ", err.Error())
+		response := models.UpdateAttendanceResponse{
+			MessageType: 6,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeDeviceMisconfigured,
+		}
+
+		p.publish(client, route, response)
+		return
+	}
+
+	institutionLocation, err := p.loadLocation(settings.InstitutionTimezone)
+
+	if err != nil {
+		log.Println("invalid institution timezone, DeviceId: ", deviceId, " Timezone: ", settings.InstitutionTimezone, " Error: ", err.Error())
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
 			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeDeviceMisconfigured,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
-	t, err := time.Parse("2006-01-02T15:04:05", req.TimeStamp)
+	t, err := scannedAt(deviceLocation)
 
 	if err != nil {
 		log.Println("error occurred while parsing the attendance timestamp, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
 			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidTimestamp,
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
-	date := t.Format("2006-01-02")
+	t = t.In(institutionLocation)
+
+	date := attendance.NewCalendar(settings).BusinessDate(t)
+
+	punch := &models.Punch{
+		UnitId:        deviceId,
+		StudentUnitId: strconv.Itoa(int(req.StudentUnitId)),
+		StudentId:     studentId,
+		Index:         req.Index,
+		ScannedAt:     t,
+		ReceivedAt:    receivedAt,
+		BusinessDate:  date,
+	}
 
-	tm := t.Format("15:04")
+	unlock := p.lockStudent(studentId)
+	defer unlock()
 
-	isLogout, err := p.dbRepo.CheckLoginOrLogout(studentId, date)
+	openAttendance, isLogout, err := p.dbRepo.GetOpenAttendance(studentId, t.Add(-p.maxSession))
 
 	if err != nil {
 		log.Println("error occurred with database while checking attedance login or logout, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
-			ErrorStatus: 1,
+			ErrorStatus: errorStatus(err),
+			ErrorCode:   errorCode(err),
 		}
 
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 		return
 	}
 
 	if isLogout {
-		if err := p.dbRepo.UpdateAttendanceLog(studentId, date, tm); err != nil {
+		debounce := p.debounce
+
+		if settings.HasScanDebounce {
+			debounce = settings.ScanDebounce
+		}
+
+		if t.Sub(openAttendance.LoginAt) < debounce {
+			log.Println("ignoring repeated attendance scan within the debounce window, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId)
+
+			if _, err := p.dbRepo.InsertPunch(punch); err != nil {
+				log.Println("error occurred with database while inserting the attendance punch, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
+				response := models.UpdateAttendanceResponse{
+					MessageType: 6,
+					ErrorStatus: errorStatus(err),
+					ErrorCode:   errorCode(err),
+				}
+
+				p.publish(client, route, response)
+				return
+			}
+
+			response := models.UpdateAttendanceResponse{
+				MessageType:   6,
+				ErrorStatus:   0,
+				Index:         req.Index,
+				DuplicateScan: 1,
+			}
+			p.publish(client, route, response)
+			return
+		}
+
+		openAttendance.LogoutAt = &t
+
+		recorded, err := p.dbRepo.UpdateAttendanceLog(openAttendance, punch)
+
+		if err != nil {
 			log.Println("error occurred while updating the student attendance, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
 			response := models.UpdateAttendanceResponse{
 				MessageType: 6,
-				ErrorStatus: 1,
+				ErrorStatus: errorStatus(err),
+				ErrorCode:   errorCode(err),
 			}
 
-			responseJson, _ := json.Marshal(response)
-			client.Publish(deviceId, 1, false, responseJson)
+			p.publish(client, route, response)
 			return
 		}
 
+		if !recorded {
+			log.Println("attendance punch already recorded, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, "Index: ", req.Index)
+		} else {
+			p.emitAttendance(punch, "logout")
+		}
+
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
 			ErrorStatus: 0,
 			Index:       req.Index,
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 	} else {
 		att := new(models.Attendance)
 
 		att.StudentId = studentId
 		att.Date = date
-		att.Login = tm
-		att.Logout = "25:00"
+		att.LoginAt = t
+
+		recorded, err := p.dbRepo.InsertAttendanceLog(att, punch)
 
-		if err := p.dbRepo.InsertAttendanceLog(att); err != nil {
+		if err != nil {
 			log.Println("error occurred with database while inserting the attendance, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Error: ", err.Error())
 			response := models.UpdateAttendanceResponse{
 				MessageType: 6,
-				ErrorStatus: 1,
+				ErrorStatus: errorStatus(err),
+				ErrorCode:   errorCode(err),
 			}
 
-			responseJson, _ := json.Marshal(response)
-			client.Publish(deviceId, 1, false, responseJson)
+			p.publish(client, route, response)
 			return
 		}
 
+		if !recorded {
+			log.Println("attendance punch already recorded, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, "Index: ", req.Index)
+		} else {
+			p.emitAttendance(punch, "login")
+		}
+
 		response := models.UpdateAttendanceResponse{
 			MessageType: 6,
 			ErrorStatus: 0,
 			Index:       req.Index,
 		}
-		responseJson, _ := json.Marshal(response)
-		client.Publish(deviceId, 1, false, responseJson)
+		p.publish(client, route, response)
 	}
 
 }
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"log"
+	"maps"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+type messageHandler func(p *messageProcessor, client mqtt.Client, route topic.Route, message []byte)
+
+var protocolV1Handlers = map[string]messageHandler{
+	"connection":         (*messageProcessor).processDeviceConnectionRequest,
+	"disconnection":      (*messageProcessor).processDeviceDisconnectionRequest,
+	"deletesync":         (*messageProcessor).processDeviceDeleteSyncRequest,
+	"deletesyncack":      (*messageProcessor).processDeviceDeleteSyncAckRequest,
+	"insertsync":         (*messageProcessor).processDeviceInsertSyncRequest,
+	"insertsyncack":      (*messageProcessor).processDeviceInsertSyncAckRequest,
+	"insertsyncchunkack": (*messageProcessor).processDeviceInsertSyncChunkAckRequest,
+	"attendance":         (*messageProcessor).processAttendanceRequest,
+}
+
+// protocolHandlers maps each supported version to its handlers, a version only
+// lists the message types that differ from the version before it.
+var protocolHandlers = map[uint8]map[string]messageHandler{
+	models.ProtocolV1: protocolV1Handlers,
+	models.ProtocolV2: withHandlers(protocolV1Handlers, map[string]messageHandler{
+		"attendance": (*messageProcessor).processAttendanceRequestV2,
+	}),
+}
+
+func withHandlers(base map[string]messageHandler, overrides map[string]messageHandler) map[string]messageHandler {
+	handlers := maps.Clone(base)
+	maps.Copy(handlers, overrides)
+	return handlers
+}
+
+// protocolVersion resolves the version of a message, the topic takes precedence
+// over the version stored with the device at connection time.
+func (p *messageProcessor) protocolVersion(route topic.Route) (uint8, bool) {
+	if route.Version != "" {
+		return models.ParseProtocolVersion(route.Version)
+	}
+
+	return p.deviceProtocolVersion(route.DeviceId), true
+}
+
+func (p *messageProcessor) deviceProtocolVersion(deviceId string) uint8 {
+	version, err := p.versions.Load(deviceId, func() (uint8, error) {
+		version, err := p.dbRepo.GetDeviceProtocolVersion(deviceId)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if !models.IsSupportedProtocolVersion(version) {
+			version = models.DefaultProtocolVersion
+		}
+
+		return version, nil
+	})
+
+	if err != nil {
+		log.Println("error occurred with database while getting the device protocol version, Device Id: ", deviceId, " Error: ", err.Error())
+		return models.DefaultProtocolVersion
+	}
+
+	return version
+}
+
+func (p *messageProcessor) recordProtocolVersion(deviceId string, version uint8) {
+	name := models.FormatProtocolVersion(version)
+
+	metrics.ProtocolMessages.Add(name, 1)
+
+	if p.deprecatedVersions[version] {
+		metrics.DeprecatedProtocolMessages.Add(name, 1)
+		metrics.DeprecatedProtocolDevices.Set(deviceId, metrics.Text(name))
+	} else {
+		metrics.DeprecatedProtocolDevices.Delete(deviceId)
+	}
+}
+
+func (p *messageProcessor) processAttendanceRequestV2(client mqtt.Client, route topic.Route, message []byte) {
+	deviceId := route.DeviceId
+
+	receivedAt := time.Now()
+
+	req := new(models.UpdateAttendanceRequestV2)
+
+	if err := p.decode(message, req); err != nil {
+		log.Println("error occurred while decoding the update attendance request, DeviceId:", deviceId, " Error: ", err.Error())
+
+		response := models.UpdateAttendanceResponse{
+			MessageType: 6,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidPayload,
+		}
+
+		p.publish(client, route, response)
+		return
+	}
+
+	if req.ScannedAt <= 0 {
+		log.Println("invalid attendance timestamp, DeviceId: ", deviceId, "StudentUnitId: ", req.StudentUnitId, " Timestamp: ", strconv.FormatInt(req.ScannedAt, 10))
+
+		response := models.UpdateAttendanceResponse{
+			MessageType: 6,
+			ErrorStatus: 1,
+			ErrorCode:   models.ErrorCodeInvalidTimestamp,
+		}
+
+		p.publish(client, route, response)
+		return
+	}
+
+	attendanceReq := &models.UpdateAttendanceRequest{
+		StudentUnitId: req.StudentUnitId,
+		Index:         req.Index,
+	}
+
+	p.recordAttendance(client, route, attendanceReq, receivedAt, func(*time.Location) (time.Time, error) {
+		return time.Unix(req.ScannedAt, 0), nil
+	})
+}
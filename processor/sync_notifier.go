@@ -0,0 +1,189 @@
+package processor
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+const (
+	SyncNotifyListen = "listen"
+	SyncNotifyPoll   = "poll"
+	SyncNotifyOff    = "off"
+
+	syncListenRetryInterval = 5 * time.Second
+)
+
+// syncNotifier tells devices that rows are waiting in inserts or deletes so that
+// they do not have to poll with insertsync and deletesync.
+type syncNotifier struct {
+	processor    *messageProcessor
+	dbRepo       models.SyncNotifyDatabaseInterface
+	mode         string
+	pollInterval time.Duration
+	mu           sync.Mutex
+	lastNotified map[string]models.PendingSync
+}
+
+func NewSyncNotifier(processor *messageProcessor, dbRepo models.SyncNotifyDatabaseInterface, mode string, pollInterval time.Duration) *syncNotifier {
+	return &syncNotifier{
+		processor:    processor,
+		dbRepo:       dbRepo,
+		mode:         mode,
+		pollInterval: pollInterval,
+		lastNotified: make(map[string]models.PendingSync),
+	}
+}
+
+func (n *syncNotifier) Start() {
+	switch n.mode {
+	case SyncNotifyListen:
+		go func() {
+			for {
+				err := n.dbRepo.ListenSyncQueue(func(deviceId string) {
+					n.processor.NotifySyncAvailable(deviceId)
+				})
+
+				log.Println("sync queue listener stopped, retrying, Error: ", err.Error())
+
+				time.Sleep(syncListenRetryInterval)
+
+				//notifications sent while the listener was down are lost
+				n.NotifyOnlineDevices()
+			}
+		}()
+	case SyncNotifyPoll:
+		go func() {
+			for {
+				time.Sleep(n.pollInterval)
+				n.notifyOnlineDevices(true)
+			}
+		}()
+	}
+}
+
+// NotifyOnlineDevices notifies every online device that has pending sync rows.
+func (n *syncNotifier) NotifyOnlineDevices() {
+	if n.mode == SyncNotifyOff {
+		return
+	}
+
+	n.notifyOnlineDevices(false)
+}
+
+func (n *syncNotifier) notifyOnlineDevices(onlyChanged bool) {
+	devices, err := n.dbRepo.ListOnlinePendingSync()
+
+	if err != nil {
+		log.Println("error occurred with database while listing devices with pending sync, Error: ", err.Error())
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notified := make(map[string]models.PendingSync, len(devices))
+
+	for _, pending := range devices {
+		notified[pending.UnitId] = pending
+
+		if onlyChanged && n.lastNotified[pending.UnitId] == pending {
+			continue
+		}
+
+		n.processor.publishSyncAvailable(&pending)
+	}
+
+	n.lastNotified = notified
+}
+
+func (p *messageProcessor) NotifySyncAvailable(deviceId string) {
+	pending, err := p.dbRepo.GetPendingSyncCounts(deviceId)
+
+	if err != nil {
+		log.Println("error occurred with database while counting the pending sync rows, Device Id: ", deviceId, " Error: ", err.Error())
+		return
+	}
+
+	if pending.Inserts == 0 && pending.Deletes == 0 {
+		return
+	}
+
+	p.publishSyncAvailable(pending)
+}
+
+func (p *messageProcessor) publishSyncAvailable(pending *models.PendingSync) {
+	command := models.SyncAvailableCommand{
+		MessageType:    7,
+		PendingInserts: clampUint16(pending.Inserts),
+		PendingDeletes: clampUint16(pending.Deletes),
+	}
+
+	route, err := p.deviceRoute(pending.UnitId)
+
+	if err != nil {
+		log.Println("error occurred with database while getting the device topic route, Device Id: ", pending.UnitId, " Error: ", err.Error())
+		return
+	}
+
+	p.publish(p.mqttClient, route, command)
+}
+
+// deviceRoute returns the route of the last message of a device, so that server
+// initiated messages reach devices behind tenant or site prefixes. Devices that sent
+// nothing since a restart are addressed with the tenant and site of their last
+// connection, a device that never stored them fails to format a topic with those
+// placeholders and is not notified.
+func (p *messageProcessor) deviceRoute(deviceId string) (topic.Route, error) {
+	if route, ok := p.routes.Load(deviceId); ok {
+		return route.(topic.Route), nil
+	}
+
+	stored, err := p.topicRoutes.Load(deviceId, func() (topic.Route, error) {
+		tenant, site, err := p.dbRepo.GetDeviceTopicRoute(deviceId)
+		return topic.Route{Tenant: tenant, Site: site}, err
+	})
+
+	if err != nil {
+		return topic.Route{}, err
+	}
+
+	return topic.Route{
+		Tenant:   stored.Tenant,
+		Site:     stored.Site,
+		DeviceId: deviceId,
+		Version:  models.FormatProtocolVersion(p.deviceProtocolVersion(deviceId)),
+	}, nil
+}
+
+// registerTopicRoute stores the tenant and site of the topic of a connection request,
+// request topics without those placeholders leave nothing to store.
+func (p *messageProcessor) registerTopicRoute(route topic.Route) error {
+	if route.Tenant == "" && route.Site == "" {
+		return nil
+	}
+
+	stored := topic.Route{Tenant: route.Tenant, Site: route.Site}
+
+	if current, ok := p.topicRoutes.Get(route.DeviceId); ok && current == stored {
+		return nil
+	}
+
+	if err := p.dbRepo.SetDeviceTopicRoute(route.DeviceId, route.Tenant, route.Site); err != nil {
+		return err
+	}
+
+	p.topicRoutes.Set(route.DeviceId, stored)
+
+	return nil
+}
+
+func clampUint16(value int) uint16 {
+	if value > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(value)
+}
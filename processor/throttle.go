@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"log"
+	"math"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+// responseMessageTypes is the mty of the response to each request type, requests
+// without a response, like disconnection, are throttled silently.
+var responseMessageTypes = map[string]uint8{
+	"connection":         1,
+	"deletesync":         2,
+	"deletesyncack":      3,
+	"insertsync":         4,
+	"insertsyncack":      5,
+	"attendance":         6,
+	"insertsyncchunkack": 8,
+}
+
+// allow runs on the mqtt client goroutine, so it must not touch the database.
+// Messages that do not parse are let through for processMessage to drop.
+func (p *messageProcessor) allow(message mqtt.Message) bool {
+	if p.limiter == nil {
+		return true
+	}
+
+	route, ok := p.requestTopic.Parse(message.Topic())
+
+	if !ok {
+		return true
+	}
+
+	messageType, typeCodec := codec.SplitType(route.Type)
+
+	allowed, retryAfter, reply := p.limiter.Allow(route.DeviceId, messageType)
+
+	if allowed {
+		return true
+	}
+
+	metrics.ThrottledMessages.Add(messageType, 1)
+
+	if !reply {
+		return false
+	}
+
+	//only devices that passed the registry check have a route, other ids are not answered
+	stored, ok := p.routes.Load(route.DeviceId)
+
+	if !ok {
+		return false
+	}
+
+	log.Println("device is over the rate limit, Device Id: ", route.DeviceId, " Message Type: ", messageType)
+
+	responseType, ok := responseMessageTypes[messageType]
+
+	if !ok {
+		return false
+	}
+
+	route = stored.(topic.Route)
+	route.Type = messageType
+
+	response := models.ThrottledResponse{
+		MessageType: responseType,
+		ErrorStatus: models.ErrorStatusFor(models.ErrorCodeThrottled),
+		ErrorCode:   models.ErrorCodeThrottled,
+		RetryAfter:  clampUint16(int(math.Ceil(retryAfter.Seconds()))),
+	}
+
+	p.publishWith(p.mqttClient, route, p.cachedCodec(route.DeviceId, typeCodec, message.Payload()), response)
+
+	return false
+}
+
+// cachedCodec picks the response encoding without a database lookup, falling back
+// to the encoding the request was sent in.
+func (p *messageProcessor) cachedCodec(deviceId string, typeCodec codec.Codec, message []byte) codec.Codec {
+	if typeCodec != nil {
+		return typeCodec
+	}
+
+	if c, ok := p.codecs.Get(deviceId); ok {
+		return c
+	}
+
+	return codec.Detect(message)
+}
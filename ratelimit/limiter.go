@@ -0,0 +1,291 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const (
+	// DefaultType is the rule used for message types without their own rule.
+	DefaultType = "default"
+
+	// DeviceType is the rule shared by all the messages of a device.
+	DeviceType = "device"
+
+	// message type of the buckets that count every message of a device
+	allTypes = "*"
+
+	// a device stays flagged this long after its last throttled message
+	flagWindow = 10 * time.Minute
+
+	// at most one throttled response per bucket is sent in this interval, so a
+	// looping device does not turn into a looping publisher
+	replyInterval = time.Second
+
+	sweepInterval = time.Minute
+)
+
+// Rule is a token bucket refilled with Rate tokens per second up to Burst tokens.
+type Rule struct {
+	Rate  float64
+	Burst float64
+}
+
+type key struct {
+	deviceId    string
+	messageType string
+}
+
+type bucket struct {
+	tokens          float64
+	updatedAt       time.Time
+	throttled       int64
+	lastThrottledAt time.Time
+	lastReplyAt     time.Time
+}
+
+// Limiter keeps one token bucket per device and message type.
+type Limiter struct {
+	rules   map[string]Rule
+	mu      sync.Mutex
+	buckets map[key]*bucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+// ParseRules reads limits written as "type=rate/burst" separated by commas, for
+// example "device=20/40,default=5/10,insertsync=1/3". Without a burst the
+// bucket holds one second worth of tokens.
+func ParseRules(spec string) (map[string]Rule, error) {
+	rules := make(map[string]Rule)
+
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		messageType, limit, ok := strings.Cut(entry, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected type=rate/burst", entry)
+		}
+
+		messageType = strings.TrimSpace(messageType)
+
+		if messageType == "" {
+			return nil, fmt.Errorf("invalid rate limit %q, missing message type", entry)
+		}
+
+		rateValue, burstValue, _ := strings.Cut(limit, "/")
+
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateValue), 64)
+
+		if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+			return nil, fmt.Errorf("invalid rate in rate limit %q", entry)
+		}
+
+		burst := rate
+
+		if burstValue = strings.TrimSpace(burstValue); burstValue != "" {
+			burst, err = strconv.ParseFloat(burstValue, 64)
+
+			if err != nil || burst < 1 || math.IsInf(burst, 0) {
+				return nil, fmt.Errorf("invalid burst in rate limit %q", entry)
+			}
+		}
+
+		if burst < 1 {
+			burst = 1
+		}
+
+		if _, ok := rules[messageType]; ok {
+			return nil, fmt.Errorf("duplicate rate limit for %q", messageType)
+		}
+
+		rules[messageType] = Rule{Rate: rate, Burst: burst}
+	}
+
+	return rules, nil
+}
+
+// NewLimiter returns nil when there are no rules, message types without a rule
+// and without a default rule are not limited.
+func NewLimiter(rules map[string]Rule) *Limiter {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	l := &Limiter{
+		rules:   rules,
+		buckets: make(map[key]*bucket),
+		now:     time.Now,
+	}
+
+	metrics.PublishCounts("throttled_devices", l.throttledCounts)
+
+	return l
+}
+
+// Allow takes a token from the bucket of the message type and from the bucket of
+// the device, and takes neither when one of them is empty. Then it returns how long
+// until the next token and whether the device should be told, which is at most once
+// per second and bucket.
+func (l *Limiter) Allow(deviceId string, messageType string) (bool, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	l.sweep(now)
+
+	var taken []*bucket
+
+	for _, k := range []key{{deviceId: deviceId, messageType: messageType}, {deviceId: deviceId, messageType: allTypes}} {
+		b, rule := l.refill(now, k)
+
+		if b == nil {
+			continue
+		}
+
+		if b.tokens < 1 {
+			retryAfter, reply := b.throttle(now, rule)
+			return false, retryAfter, reply
+		}
+
+		taken = append(taken, b)
+	}
+
+	for _, b := range taken {
+		b.tokens--
+	}
+
+	return true, 0, false
+}
+
+// refill returns the bucket of k topped up with the tokens earned since its last
+// use, or nil when k is not limited.
+func (l *Limiter) refill(now time.Time, k key) (*bucket, Rule) {
+	rule, ok := l.rule(k.messageType)
+
+	if !ok {
+		return nil, rule
+	}
+
+	b, ok := l.buckets[k]
+
+	if !ok {
+		b = &bucket{tokens: rule.Burst, updatedAt: now}
+		l.buckets[k] = b
+	}
+
+	b.tokens = math.Min(rule.Burst, b.tokens+now.Sub(b.updatedAt).Seconds()*rule.Rate)
+	b.updatedAt = now
+
+	return b, rule
+}
+
+// throttle records a message rejected by the empty bucket b.
+func (b *bucket) throttle(now time.Time, rule Rule) (time.Duration, bool) {
+	retryAfter := time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+
+	b.throttled++
+	b.lastThrottledAt = now
+
+	reply := now.Sub(b.lastReplyAt) >= replyInterval
+
+	if reply {
+		b.lastReplyAt = now
+	}
+
+	return retryAfter, reply
+}
+
+func (l *Limiter) rule(messageType string) (Rule, bool) {
+	if messageType == allTypes {
+		rule, ok := l.rules[DeviceType]
+		return rule, ok
+	}
+
+	if rule, ok := l.rules[messageType]; ok {
+		return rule, true
+	}
+
+	rule, ok := l.rules[DefaultType]
+	return rule, ok
+}
+
+// ThrottledDevices lists the buckets throttled within the flag window, most
+// recently throttled first.
+func (l *Limiter) ThrottledDevices() []models.ThrottledDevice {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	devices := make([]models.ThrottledDevice, 0)
+
+	for k, b := range l.buckets {
+		if b.throttled == 0 || now.Sub(b.lastThrottledAt) > flagWindow {
+			continue
+		}
+
+		devices = append(devices, models.ThrottledDevice{
+			DeviceId:        k.deviceId,
+			MessageType:     k.messageType,
+			Throttled:       b.throttled,
+			LastThrottledAt: b.lastThrottledAt,
+		})
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastThrottledAt.After(devices[j].LastThrottledAt)
+	})
+
+	return devices
+}
+
+// throttledCounts sums the throttled messages of each device over its buckets, swept
+// buckets drop out so only the devices flagged recently are listed.
+func (l *Limiter) throttledCounts() map[string]int64 {
+	counts := make(map[string]int64)
+
+	for _, device := range l.ThrottledDevices() {
+		counts[device.DeviceId] += device.Throttled
+	}
+
+	return counts
+}
+
+// sweep drops buckets that are full again and no longer flagged, device ids come
+// from topics so the map must not grow without bound. Callers hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < sweepInterval {
+		return
+	}
+
+	l.sweptAt = now
+
+	for k, b := range l.buckets {
+		rule, _ := l.rule(k.messageType)
+
+		idle := now.Sub(b.updatedAt)
+
+		if b.tokens+idle.Seconds()*rule.Rate < rule.Burst {
+			continue
+		}
+
+		if b.throttled > 0 && now.Sub(b.lastThrottledAt) <= flagWindow {
+			continue
+		}
+
+		delete(l.buckets, k)
+	}
+}
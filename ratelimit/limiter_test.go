@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter whose clock only moves when the test advances it.
+func testLimiter(t *testing.T, spec string) (*Limiter, func(time.Duration)) {
+	t.Helper()
+
+	rules, err := ParseRules(spec)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLimiter(rules)
+
+	now := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func allowN(l *Limiter, deviceId string, messageType string, n int) int {
+	allowed := 0
+
+	for i := 0; i < n; i++ {
+		if ok, _, _ := l.Allow(deviceId, messageType); ok {
+			allowed++
+		}
+	}
+
+	return allowed
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]Rule
+		wantErr bool
+	}{
+		{"", map[string]Rule{}, false},
+		{"device=20/40, default=5/10,insertsync=1/3", map[string]Rule{"device": {20, 40}, "default": {5, 10}, "insertsync": {1, 3}}, false},
+		{"attendance=2", map[string]Rule{"attendance": {2, 2}}, false},
+		{"attendance=0.5", map[string]Rule{"attendance": {0.5, 1}}, false},
+		{"attendance", nil, true},
+		{"=1/2", nil, true},
+		{"attendance=0/2", nil, true},
+		{"attendance=1/0.5", nil, true},
+		{"attendance=x", nil, true},
+		{"attendance=1,attendance=2", nil, true},
+	}
+
+	for _, test := range tests {
+		rules, err := ParseRules(test.spec)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseRules(%q): got error %v, want error %v", test.spec, err, test.wantErr)
+			continue
+		}
+
+		if test.wantErr {
+			continue
+		}
+
+		if len(rules) != len(test.want) {
+			t.Errorf("ParseRules(%q): got %v, want %v", test.spec, rules, test.want)
+			continue
+		}
+
+		for messageType, rule := range test.want {
+			if rules[messageType] != rule {
+				t.Errorf("ParseRules(%q)[%q]: got %v, want %v", test.spec, messageType, rules[messageType], rule)
+			}
+		}
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	l, advance := testLimiter(t, "attendance=2/4")
+
+	if got := allowN(l, "vs23cg003", "attendance", 6); got != 4 {
+		t.Fatalf("got %v allowed from a full bucket, want the burst of 4", got)
+	}
+
+	//two tokens per second
+	advance(time.Second)
+
+	if got := allowN(l, "vs23cg003", "attendance", 3); got != 2 {
+		t.Errorf("got %v allowed after one second, want 2", got)
+	}
+
+	//never more than the burst
+	advance(time.Hour)
+
+	if got := allowN(l, "vs23cg003", "attendance", 6); got != 4 {
+		t.Errorf("got %v allowed after an hour, want the burst of 4", got)
+	}
+}
+
+func TestLimiterTypeAndDeviceLimits(t *testing.T) {
+	l, _ := testLimiter(t, "device=3/3,attendance=2/2")
+
+	if got := allowN(l, "vs23cg003", "attendance", 3); got != 2 {
+		t.Errorf("got %v attendance messages, want the type limit of 2", got)
+	}
+
+	//types without a rule and without a default are only held by the device limit
+	if got := allowN(l, "vs23cg003", "insertsync", 3); got != 1 {
+		t.Errorf("got %v insertsync messages, want the last device token", got)
+	}
+
+	//other devices have buckets of their own
+	if got := allowN(l, "vs23cg004", "attendance", 1); got != 1 {
+		t.Errorf("got %v for another device, want 1", got)
+	}
+}
+
+func TestLimiterRejectionTakesNoToken(t *testing.T) {
+	l, _ := testLimiter(t, "device=1/1,attendance=5/5")
+
+	if got := allowN(l, "vs23cg003", "insertsync", 1); got != 1 {
+		t.Fatalf("got %v, want the device token", got)
+	}
+
+	//rejected by the device bucket, the attendance bucket keeps its tokens
+	allowN(l, "vs23cg003", "attendance", 10)
+
+	b := l.buckets[key{deviceId: "vs23cg003", messageType: "attendance"}]
+
+	if b == nil || b.tokens != 5 {
+		t.Errorf("got attendance bucket %+v, want 5 tokens left", b)
+	}
+}
+
+func TestLimiterRetryAfterAndReply(t *testing.T) {
+	l, advance := testLimiter(t, "attendance=0.5/1")
+
+	allowN(l, "vs23cg003", "attendance", 1)
+
+	ok, retryAfter, reply := l.Allow("vs23cg003", "attendance")
+
+	if ok || retryAfter != 2*time.Second || !reply {
+		t.Errorf("got %v %v %v, want a rejection retrying after 2s with a reply", ok, retryAfter, reply)
+	}
+
+	//at most one reply per second and bucket
+	if _, _, reply := l.Allow("vs23cg003", "attendance"); reply {
+		t.Error("replied twice within a second")
+	}
+
+	advance(time.Second)
+
+	ok, retryAfter, reply = l.Allow("vs23cg003", "attendance")
+
+	if ok || retryAfter != time.Second || !reply {
+		t.Errorf("got %v %v %v, want a rejection retrying after 1s with a reply", ok, retryAfter, reply)
+	}
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	l, advance := testLimiter(t, "attendance=1/2")
+
+	allowN(l, "vs23cg003", "attendance", 1)
+	allowN(l, "vs23cg004", "attendance", 3)
+
+	if devices := l.ThrottledDevices(); len(devices) != 1 || devices[0].DeviceId != "vs23cg004" || devices[0].Throttled != 1 {
+		t.Errorf("got throttled devices %+v, want vs23cg004 once", devices)
+	}
+
+	//refilled but still flagged
+	advance(sweepInterval)
+	allowN(l, "vs23cg005", "attendance", 1)
+
+	if _, ok := l.buckets[key{deviceId: "vs23cg003", messageType: "attendance"}]; ok {
+		t.Error("the refilled bucket of vs23cg003 was not swept")
+	}
+
+	if _, ok := l.buckets[key{deviceId: "vs23cg004", messageType: "attendance"}]; !ok {
+		t.Error("the flagged bucket of vs23cg004 was swept")
+	}
+
+	advance(flagWindow)
+	allowN(l, "vs23cg005", "attendance", 1)
+
+	if _, ok := l.buckets[key{deviceId: "vs23cg004", messageType: "attendance"}]; ok {
+		t.Error("the bucket of vs23cg004 was kept after the flag window")
+	}
+
+	if counts := l.throttledCounts(); len(counts) != 0 {
+		t.Errorf("got throttled counts %v after the flag window, want none", counts)
+	}
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const deviceStatusQuery = `SELECT b.unit_id, b.online, b.last_seen_at,
+		( SELECT COUNT(*) FROM inserts i WHERE i.unit_id = b.unit_id AND i.quarantined_at IS NULL ),
+		( SELECT COUNT(*) FROM deletes d WHERE d.unit_id = b.unit_id )
+	FROM biometric b`
+
+func syncQueueTable(queue string) (string, error) {
+	switch queue {
+	case models.SyncQueueInserts, models.SyncQueueDeletes:
+		return queue, nil
+	default:
+		return "", fmt.Errorf("unknown sync queue %q", queue)
+	}
+}
+
+func scanDeviceStatus(row pgx.Row) (*models.DeviceStatus, error) {
+	device := new(models.DeviceStatus)
+	err := row.Scan(&device.UnitId, &device.Online, &device.LastSeenAt, &device.PendingInserts, &device.PendingDeletes)
+	return device, err
+}
+
+func (repo *postgresRepository) TouchDevice(deviceId string) error {
+	query := `UPDATE biometric SET last_seen_at=now() WHERE unit_id=$1`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId)
+	return err
+}
+
+func (repo *postgresRepository) ListDevices() ([]models.DeviceStatus, error) {
+	rows, err := repo.dbConn.Query(context.Background(), deviceStatusQuery+` ORDER BY b.unit_id`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	devices := []models.DeviceStatus{}
+
+	for rows.Next() {
+		device, err := scanDeviceStatus(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, *device)
+	}
+
+	return devices, rows.Err()
+}
+
+func (repo *postgresRepository) GetDevice(deviceId string) (*models.DeviceStatus, error) {
+	device, err := scanDeviceStatus(repo.dbConn.QueryRow(context.Background(), deviceStatusQuery+` WHERE b.unit_id=$1`, deviceId))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, models.ErrNotFound
+	}
+
+	return device, err
+}
+
+func (repo *postgresRepository) ListSyncItems(deviceId string, queue string) ([]models.SyncItem, error) {
+	table, err := syncQueueTable(queue)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT student_unit_id, NULL::TIMESTAMPTZ, NULL::TEXT FROM deletes WHERE unit_id=$1 ORDER BY student_unit_id`
+
+	if table == models.SyncQueueInserts {
+		query = `SELECT student_unit_id, quarantined_at, quarantine_reason FROM inserts WHERE unit_id=$1 ORDER BY student_unit_id`
+	}
+
+	rows, err := repo.dbConn.Query(context.Background(), query, deviceId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := []models.SyncItem{}
+
+	for rows.Next() {
+		var item models.SyncItem
+
+		if err := rows.Scan(&item.StudentUnitId, &item.QuarantinedAt, &item.QuarantineReason); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (repo *postgresRepository) CancelSyncItem(deviceId string, queue string, studentUnitId string) error {
+	table, err := syncQueueTable(queue)
+
+	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM ` + table + ` WHERE unit_id=$1 AND student_unit_id=$2`
+
+	tag, err := repo.dbConn.Exec(context.Background(), query, deviceId, studentUnitId)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotFound
+	}
+
+	return nil
+}
+
+// RequeueSyncItem puts a student back on the queue of a device. Inserts are rebuilt
+// from the enrolled template in fingerprintdata, so the student must be enrolled, and
+// replace a quarantined row of the same student.
+func (repo *postgresRepository) RequeueSyncItem(deviceId string, queue string, studentUnitId string) error {
+	table, err := syncQueueTable(queue)
+
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	tx, err := repo.dbConn.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var queued bool
+
+	query := `SELECT EXISTS ( SELECT 1 FROM deletes WHERE unit_id=$1 AND student_unit_id=$2 )`
+
+	if table == models.SyncQueueInserts {
+		query = `SELECT EXISTS ( SELECT 1 FROM inserts WHERE unit_id=$1 AND student_unit_id=$2 AND quarantined_at IS NULL )`
+	}
+
+	if err := tx.QueryRow(ctx, query, deviceId, studentUnitId).Scan(&queued); err != nil {
+		return err
+	}
+
+	if queued {
+		return models.ErrConflict
+	}
+
+	if table == models.SyncQueueInserts {
+		query = `DELETE FROM inserts WHERE unit_id=$1 AND student_unit_id=$2 AND quarantined_at IS NOT NULL`
+
+		if _, err := tx.Exec(ctx, query, deviceId, studentUnitId); err != nil {
+			return err
+		}
+
+		query = `INSERT INTO inserts (unit_id,student_unit_id,fingerprint_data,fingerprint_key_id)
+			SELECT unit_id, student_unit_id, fingerprint_data, fingerprint_key_id FROM fingerprintdata WHERE unit_id=$1 AND student_unit_id=$2`
+	} else {
+		query = `INSERT INTO deletes (unit_id,student_unit_id) VALUES ($1,$2)`
+	}
+
+	tag, err := tx.Exec(ctx, query, deviceId, studentUnitId)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return models.ErrNotFound
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (repo *postgresRepository) GetRecentPunches(deviceId string, limit int) ([]models.PunchRecord, error) {
+	query := `SELECT student_id, student_unit_id, punch_index, scanned_at, received_at, business_date::TEXT
+		FROM attendance_punches WHERE unit_id=$1 ORDER BY received_at DESC LIMIT $2`
+
+	rows, err := repo.dbConn.Query(context.Background(), query, deviceId, limit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	punches := []models.PunchRecord{}
+
+	for rows.Next() {
+		var punch models.PunchRecord
+		var index int64
+
+		if err := rows.Scan(
+			&punch.StudentId,
+			&punch.StudentUnitId,
+			&index,
+			&punch.ScannedAt,
+			&punch.ReceivedAt,
+			&punch.BusinessDate,
+		); err != nil {
+			return nil, err
+		}
+
+		punch.Index = uint32(index)
+
+		punches = append(punches, punch)
+	}
+
+	return punches, rows.Err()
+}
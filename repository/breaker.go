@@ -0,0 +1,369 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker opens after a number of consecutive database failures and then
+// fails every call with models.ErrUnavailable until the cooldown has passed. After
+// that a single probe call is let through, which closes the circuit again when
+// it succeeds and reopens it when it fails.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	b := &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     CircuitClosed,
+	}
+
+	metrics.PublishState("database_circuit", b.State)
+
+	return b
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// acquire tells whether a call may go to the database and whether it is the probe
+// of a half open circuit.
+func (b *CircuitBreaker) acquire() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitClosed:
+		return true, false
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, false
+		}
+
+		b.state = CircuitHalfOpen
+		b.probing = true
+
+		log.Println("database circuit half open, probing the database")
+
+		return true, true
+	default:
+		//only one probe at a time, the rest wait for its outcome
+		if b.probing {
+			return false, false
+		}
+
+		b.probing = true
+
+		return true, true
+	}
+}
+
+// release records the outcome of a call. Only the probe moves a half open circuit,
+// calls that started before the circuit opened and finish late are ignored.
+func (b *CircuitBreaker) release(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+
+		if !isUnavailable(err) {
+			log.Println("database circuit closed, the database is reachable again")
+
+			b.state = CircuitClosed
+			b.failures = 0
+
+			return
+		}
+
+		b.failures++
+		b.open(err)
+
+		return
+	}
+
+	if b.state != CircuitClosed {
+		return
+	}
+
+	if !isUnavailable(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= b.threshold {
+		b.open(err)
+	}
+}
+
+func (b *CircuitBreaker) open(err error) {
+	log.Println("database circuit open after ", b.failures, " consecutive failures, Error: ", err.Error())
+
+	b.state = CircuitOpen
+	b.openedAt = time.Now()
+
+	metrics.DatabaseCircuitOpened.Add(1)
+}
+
+// isUnavailable tells connection problems apart from errors that the database
+// answered with, such as a missing row or a constraint violation.
+func isUnavailable(err error) bool {
+	if err == nil || errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		//connection exception, insufficient resources and operator intervention
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			strings.HasPrefix(pgErr.Code, "53") ||
+			strings.HasPrefix(pgErr.Code, "57")
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		pgconn.Timeout(err) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func breakerCall[T any](b *CircuitBreaker, call func() (T, error)) (T, error) {
+	allowed, probe := b.acquire()
+
+	if !allowed {
+		var zero T
+		metrics.DatabaseUnavailable.Add(1)
+		return zero, models.ErrUnavailable
+	}
+
+	value, err := call()
+
+	b.release(probe, err)
+
+	return value, err
+}
+
+func breakerExec(b *CircuitBreaker, call func() error) error {
+	_, err := breakerCall(b, func() (struct{}, error) {
+		return struct{}{}, call()
+	})
+
+	return err
+}
+
+type circuitBreakerRepository struct {
+	repo    models.DeviceDatabseInterface
+	breaker *CircuitBreaker
+}
+
+// NewCircuitBreakerRepository guards every call of the device repository with the breaker.
+func NewCircuitBreakerRepository(repo models.DeviceDatabseInterface, breaker *CircuitBreaker) *circuitBreakerRepository {
+	return &circuitBreakerRepository{
+		repo,
+		breaker,
+	}
+}
+
+func (r *circuitBreakerRepository) CheckDeviceExists(deviceId string) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.CheckDeviceExists(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) UpdateDeviceStatus(deviceId string, status bool) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.UpdateDeviceStatus(deviceId, status)
+	})
+}
+
+func (r *circuitBreakerRepository) GetDeviceEncoding(deviceId string) (string, error) {
+	return breakerCall(r.breaker, func() (string, error) {
+		return r.repo.GetDeviceEncoding(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) SetDeviceEncoding(deviceId string, encoding string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.SetDeviceEncoding(deviceId, encoding)
+	})
+}
+
+func (r *circuitBreakerRepository) GetDeviceProtocolVersion(deviceId string) (uint8, error) {
+	return breakerCall(r.breaker, func() (uint8, error) {
+		return r.repo.GetDeviceProtocolVersion(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) SetDeviceProtocolVersion(deviceId string, version uint8) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.SetDeviceProtocolVersion(deviceId, version)
+	})
+}
+
+func (r *circuitBreakerRepository) GetDeviceTopicRoute(deviceId string) (string, string, error) {
+	var site string
+
+	tenant, err := breakerCall(r.breaker, func() (string, error) {
+		tenant, deviceSite, err := r.repo.GetDeviceTopicRoute(deviceId)
+		site = deviceSite
+		return tenant, err
+	})
+
+	return tenant, site, err
+}
+
+func (r *circuitBreakerRepository) SetDeviceTopicRoute(deviceId string, tenant string, site string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.SetDeviceTopicRoute(deviceId, tenant, site)
+	})
+}
+
+func (r *circuitBreakerRepository) TouchDevice(deviceId string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.TouchDevice(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) GetDeviceSettings(deviceId string) (*models.DeviceSettings, error) {
+	return breakerCall(r.breaker, func() (*models.DeviceSettings, error) {
+		return r.repo.GetDeviceSettings(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) CheckStudentsExistsInDeletes(deviceId string) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.CheckStudentsExistsInDeletes(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) GetStudentFromDeletes(deviceId string) (string, error) {
+	return breakerCall(r.breaker, func() (string, error) {
+		return r.repo.GetStudentFromDeletes(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) DeleteStudentFromDeletes(deviceId string, studentId string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.DeleteStudentFromDeletes(deviceId, studentId)
+	})
+}
+
+func (r *circuitBreakerRepository) CheckStudentsExistsInInserts(deviceId string) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.CheckStudentsExistsInInserts(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) GetStudentFromInserts(deviceId string) (string, string, error) {
+	var fingerprintData string
+
+	studentId, err := breakerCall(r.breaker, func() (string, error) {
+		studentId, data, err := r.repo.GetStudentFromInserts(deviceId)
+		fingerprintData = data
+		return studentId, err
+	})
+
+	return studentId, fingerprintData, err
+}
+
+func (r *circuitBreakerRepository) DeleteStudentFromInserts(deviceId string, studentId string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.DeleteStudentFromInserts(deviceId, studentId)
+	})
+}
+
+func (r *circuitBreakerRepository) QuarantineInsert(deviceId string, studentId string, reason string) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.QuarantineInsert(deviceId, studentId, reason)
+	})
+}
+
+func (r *circuitBreakerRepository) GetInsertTransfer(deviceId string, studentId string) (*models.ChunkTransfer, error) {
+	return breakerCall(r.breaker, func() (*models.ChunkTransfer, error) {
+		return r.repo.GetInsertTransfer(deviceId, studentId)
+	})
+}
+
+func (r *circuitBreakerRepository) UpdateInsertTransfer(deviceId string, studentId string, chunkSize int, nextChunk int) error {
+	return breakerExec(r.breaker, func() error {
+		return r.repo.UpdateInsertTransfer(deviceId, studentId, chunkSize, nextChunk)
+	})
+}
+
+func (r *circuitBreakerRepository) GetPendingSyncCounts(deviceId string) (*models.PendingSync, error) {
+	return breakerCall(r.breaker, func() (*models.PendingSync, error) {
+		return r.repo.GetPendingSyncCounts(deviceId)
+	})
+}
+
+func (r *circuitBreakerRepository) GetStudentId(unitId string, studentUnitId string) (string, error) {
+	return breakerCall(r.breaker, func() (string, error) {
+		return r.repo.GetStudentId(unitId, studentUnitId)
+	})
+}
+
+func (r *circuitBreakerRepository) GetOpenAttendance(studentId string, since time.Time) (*models.Attendance, bool, error) {
+	var isLogout bool
+
+	attendance, err := breakerCall(r.breaker, func() (*models.Attendance, error) {
+		attendance, open, err := r.repo.GetOpenAttendance(studentId, since)
+		isLogout = open
+		return attendance, err
+	})
+
+	return attendance, isLogout, err
+}
+
+func (r *circuitBreakerRepository) InsertPunch(punch *models.Punch) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.InsertPunch(punch)
+	})
+}
+
+func (r *circuitBreakerRepository) InsertAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.InsertAttendanceLog(attendanceLog, punch)
+	})
+}
+
+func (r *circuitBreakerRepository) UpdateAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	return breakerCall(r.breaker, func() (bool, error) {
+		return r.repo.UpdateAttendanceLog(attendanceLog, punch)
+	})
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+func failCall() error {
+	return io.ErrUnexpectedEOF
+}
+
+func succeedCall() error {
+	return nil
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(3, time.Hour)
+
+	breakerExec(breaker, failCall)
+	breakerExec(breaker, failCall)
+
+	//a success resets the count of a closed circuit
+	breakerExec(breaker, succeedCall)
+
+	breakerExec(breaker, failCall)
+	breakerExec(breaker, failCall)
+
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("got %v after two failures, want closed", state)
+	}
+
+	breakerExec(breaker, failCall)
+
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("got %v after three failures, want open", state)
+	}
+
+	if err := breakerExec(breaker, succeedCall); !errors.Is(err, models.ErrUnavailable) {
+		t.Errorf("got %v from an open circuit, want ErrUnavailable", err)
+	}
+}
+
+func TestBreakerIgnoresLateResultsWhileOpen(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Hour)
+
+	//a call that started while closed and finishes after the circuit opened
+	allowed, probe := breaker.acquire()
+
+	if !allowed || probe {
+		t.Fatalf("closed circuit: got allowed %v probe %v", allowed, probe)
+	}
+
+	breakerExec(breaker, failCall)
+
+	breaker.release(probe, nil)
+
+	if state := breaker.State(); state != CircuitOpen {
+		t.Errorf("got %v after a late success, want open", state)
+	}
+}
+
+func TestBreakerProbeDecidesHalfOpen(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 0)
+
+	late, _ := breaker.acquire()
+
+	if !late {
+		t.Fatal("closed circuit refused a call")
+	}
+
+	breakerExec(breaker, failCall)
+
+	//the cooldown has passed, the next call is the probe
+	allowed, probe := breaker.acquire()
+
+	if !allowed || !probe || breaker.State() != CircuitHalfOpen {
+		t.Fatalf("got allowed %v probe %v state %v, want the probe of a half open circuit", allowed, probe, breaker.State())
+	}
+
+	if other, _ := breaker.acquire(); other {
+		t.Error("a second call was let through while probing")
+	}
+
+	//a late result does not stand in for the probe
+	breaker.release(false, nil)
+
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Errorf("got %v after a late success, want half-open", state)
+	}
+
+	breaker.release(probe, failCall())
+
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("got %v after a failed probe, want open", state)
+	}
+
+	if err := breakerExec(breaker, succeedCall); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("got %v after a successful probe, want closed", state)
+	}
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/cache"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const registryListenRetryInterval = 5 * time.Second
+
+// unknown devices are cached apart from the registered ones and for a shorter time,
+// device ids come from topics and made up ones must not push registered devices out
+const (
+	unknownDeviceTtl        = 30 * time.Second
+	unknownDeviceMaxEntries = 1000
+)
+
+type studentCacheKey struct {
+	unitId        string
+	studentUnitId string
+}
+
+// studentMapping caches unknown student unit ids as well, so a device scanning an
+// unenrolled finger does not reach the database on every scan.
+type studentMapping struct {
+	studentId string
+	enrolled  bool
+}
+
+// cachedRepository serves device existence and the student unit id mapping from
+// memory, every other call goes to the wrapped repository.
+type cachedRepository struct {
+	models.DeviceDatabseInterface
+	devices        *cache.Cache[string, bool]
+	unknownDevices *cache.Cache[string, bool]
+	students       *cache.Cache[studentCacheKey, studentMapping]
+}
+
+func NewCachedRepository(repo models.DeviceDatabseInterface, ttl time.Duration, maxEntries int) *cachedRepository {
+	return &cachedRepository{
+		DeviceDatabseInterface: repo,
+		devices:                cache.New[string, bool]("device_registry", ttl, maxEntries),
+		unknownDevices:         cache.New[string, bool]("unknown_device", min(ttl, unknownDeviceTtl), min(maxEntries, unknownDeviceMaxEntries)),
+		students:               cache.New[studentCacheKey, studentMapping]("student_mapping", ttl, maxEntries),
+	}
+}
+
+// Listen invalidates entries as the database reports changes. Notifications sent
+// while the listener is down are lost, so the caches are purged on every reconnect.
+func (r *cachedRepository) Listen(dbRepo models.RegistryNotifyDatabaseInterface) {
+	go func() {
+		for {
+			err := dbRepo.ListenRegistry(r.deleteDevice, func(unitId string, studentUnitId string) {
+				r.students.Delete(studentCacheKey{unitId: unitId, studentUnitId: studentUnitId})
+			}, r.Purge)
+
+			log.Println("device registry listener stopped, retrying, Error: ", err.Error())
+
+			time.Sleep(registryListenRetryInterval)
+
+			r.Purge()
+		}
+	}()
+}
+
+func (r *cachedRepository) Purge() {
+	r.devices.Purge()
+	r.unknownDevices.Purge()
+	r.students.Purge()
+}
+
+func (r *cachedRepository) deleteDevice(deviceId string) {
+	r.devices.Delete(deviceId)
+	r.unknownDevices.Delete(deviceId)
+}
+
+func (r *cachedRepository) CheckDeviceExists(deviceId string) (bool, error) {
+	return r.devices.LoadMatching(deviceId, func() (bool, error) {
+		return r.unknownDevices.LoadMatching(deviceId, func() (bool, error) {
+			return r.DeviceDatabseInterface.CheckDeviceExists(deviceId)
+		}, isUnknown)
+	}, isRegistered)
+}
+
+func isRegistered(exists bool) bool {
+	return exists
+}
+
+func isUnknown(exists bool) bool {
+	return !exists
+}
+
+func (r *cachedRepository) GetStudentId(unitId string, studentUnitId string) (string, error) {
+	mapping, err := r.students.Load(studentCacheKey{unitId: unitId, studentUnitId: studentUnitId}, func() (studentMapping, error) {
+		studentId, err := r.DeviceDatabseInterface.GetStudentId(unitId, studentUnitId)
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return studentMapping{}, nil
+		}
+
+		return studentMapping{studentId: studentId, enrolled: err == nil}, err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if !mapping.enrolled {
+		return "", pgx.ErrNoRows
+	}
+
+	return mapping.studentId, nil
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// registryRepository answers CheckDeviceExists from a fixed set and counts the calls.
+type registryRepository struct {
+	models.DeviceDatabseInterface
+	registered map[string]bool
+	checks     map[string]int
+}
+
+func (r *registryRepository) CheckDeviceExists(deviceId string) (bool, error) {
+	r.checks[deviceId]++
+	return r.registered[deviceId], nil
+}
+
+func TestCachedRepositoryKeepsUnknownDevicesApart(t *testing.T) {
+	repo := &registryRepository{
+		registered: map[string]bool{"vs23cg003": true, "vs23cg004": true},
+		checks:     make(map[string]int),
+	}
+
+	cached := NewCachedRepository(repo, time.Hour, 2)
+
+	for _, deviceId := range []string{"vs23cg003", "vs23cg004"} {
+		if exists, err := cached.CheckDeviceExists(deviceId); err != nil || !exists {
+			t.Fatalf("got %v %v for %v, want a registered device", exists, err, deviceId)
+		}
+	}
+
+	//made up device ids fill the unknown devices, not the registered ones
+	for i := 0; i < 10; i++ {
+		if exists, err := cached.CheckDeviceExists(fmt.Sprintf("unknown-%v", i)); err != nil || exists {
+			t.Fatalf("got %v %v for an unknown device", exists, err)
+		}
+	}
+
+	for _, deviceId := range []string{"vs23cg003", "vs23cg004"} {
+		cached.CheckDeviceExists(deviceId)
+
+		if checks := repo.checks[deviceId]; checks != 1 {
+			t.Errorf("checked %v %v times, want the registered device to stay cached", deviceId, checks)
+		}
+	}
+
+	//the last unknown devices are still cached, within their own bound
+	cached.CheckDeviceExists("unknown-9")
+
+	if checks := repo.checks["unknown-9"]; checks != 1 {
+		t.Errorf("checked unknown-9 %v times, want it cached", checks)
+	}
+
+	cached.CheckDeviceExists("unknown-0")
+
+	if checks := repo.checks["unknown-0"]; checks != 2 {
+		t.Errorf("checked unknown-0 %v times, want it evicted by later unknown devices", checks)
+	}
+}
+
+func TestCachedRepositoryForgetsUnknownDeviceOnRegistration(t *testing.T) {
+	repo := &registryRepository{
+		registered: make(map[string]bool),
+		checks:     make(map[string]int),
+	}
+
+	cached := NewCachedRepository(repo, time.Hour, 10)
+
+	if exists, _ := cached.CheckDeviceExists("vs23cg003"); exists {
+		t.Fatal("got an unregistered device as registered")
+	}
+
+	//the registry notification of the new device
+	repo.registered["vs23cg003"] = true
+	cached.deleteDevice("vs23cg003")
+
+	if exists, _ := cached.CheckDeviceExists("vs23cg003"); !exists {
+		t.Error("the device is still unknown after its registration")
+	}
+}
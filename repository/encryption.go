@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const reencryptBatchSize = 100
+
+var errTemplateKeysMissing = errors.New("fingerprint template is encrypted but no encryption keys are configured")
+
+// templateAssociatedData binds an encrypted template to its device and student, so a
+// ciphertext copied to another row fails to decrypt. Requeued inserts keep the same
+// device and student as their fingerprintdata row and can copy the ciphertext as is.
+func templateAssociatedData(unitId string, studentUnitId string) []byte {
+	return []byte(unitId + "/" + studentUnitId)
+}
+
+func (repo *postgresRepository) decryptTemplate(unitId string, studentUnitId string, data string, keyId *string) (string, error) {
+	if keyId == nil {
+		return data, nil
+	}
+
+	if repo.templateEnvelope == nil {
+		return "", errTemplateKeysMissing
+	}
+
+	plaintext, err := repo.templateEnvelope.Decrypt(data, *keyId, templateAssociatedData(unitId, studentUnitId))
+
+	if err != nil {
+		return "", fmt.Errorf("%w of student %v: %w", models.ErrTemplateUndecryptable, studentUnitId, err)
+	}
+
+	return string(plaintext), nil
+}
+
+func templateTable(table string) (string, error) {
+	switch table {
+	case "inserts", "fingerprintdata":
+		return table, nil
+	default:
+		return "", fmt.Errorf("unknown fingerprint template table %q", table)
+	}
+}
+
+// ReencryptTemplates encrypts every plaintext template of the table and moves templates
+// stored under an older key to the current key. Rows that cannot be decrypted are
+// skipped and counted as failed, each update only applies if the row was not changed
+// in the meantime.
+func (repo *postgresRepository) ReencryptTemplates(table string) (int, int, error) {
+	table, err := templateTable(table)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if repo.templateEnvelope == nil {
+		return 0, 0, errors.New("no fingerprint encryption keys are configured")
+	}
+
+	ctx := context.Background()
+
+	currentKeyId := repo.templateEnvelope.CurrentKeyId()
+
+	selectQuery := `SELECT unit_id, student_unit_id, fingerprint_data, fingerprint_key_id FROM ` + table + `
+		WHERE fingerprint_key_id IS DISTINCT FROM $1 AND (unit_id, student_unit_id) > ($2, $3)
+		ORDER BY unit_id, student_unit_id LIMIT $4`
+
+	updateQuery := `UPDATE ` + table + ` SET fingerprint_data=$3, fingerprint_key_id=$4
+		WHERE unit_id=$1 AND student_unit_id=$2 AND fingerprint_data=$5 AND fingerprint_key_id IS NOT DISTINCT FROM $6`
+
+	type templateRow struct {
+		unitId        string
+		studentUnitId string
+		data          string
+		keyId         *string
+	}
+
+	var lastUnitId, lastStudentUnitId string
+	var rotated, failed int
+
+	for {
+		rows, err := repo.dbConn.Query(ctx, selectQuery, currentKeyId, lastUnitId, lastStudentUnitId, reencryptBatchSize)
+
+		if err != nil {
+			return rotated, failed, err
+		}
+
+		var batch []templateRow
+
+		for rows.Next() {
+			var row templateRow
+
+			if err := rows.Scan(&row.unitId, &row.studentUnitId, &row.data, &row.keyId); err != nil {
+				rows.Close()
+				return rotated, failed, err
+			}
+
+			batch = append(batch, row)
+		}
+
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return rotated, failed, err
+		}
+
+		for _, row := range batch {
+			plaintext, err := repo.decryptTemplate(row.unitId, row.studentUnitId, row.data, row.keyId)
+
+			if err != nil {
+				failed++
+				continue
+			}
+
+			ciphertext, keyId, err := repo.templateEnvelope.Encrypt([]byte(plaintext), templateAssociatedData(row.unitId, row.studentUnitId))
+
+			if err != nil {
+				return rotated, failed, err
+			}
+
+			tag, err := repo.dbConn.Exec(ctx, updateQuery, row.unitId, row.studentUnitId, ciphertext, keyId, row.data, row.keyId)
+
+			if err != nil {
+				return rotated, failed, err
+			}
+
+			if tag.RowsAffected() > 0 {
+				rotated++
+			}
+		}
+
+		if len(batch) < reencryptBatchSize {
+			return rotated, failed, nil
+		}
+
+		lastUnitId = batch[len(batch)-1].unitId
+		lastStudentUnitId = batch[len(batch)-1].studentUnitId
+	}
+}
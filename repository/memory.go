@@ -0,0 +1,564 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// MemoryFixture seeds a memory repository, it is read from a JSON file like
+//
+//	{
+//	  "devices": [{"unit_id": "vs23cg003", "timezone": "Asia/Kolkata", "day_start": "04:00",
+//	    "shifts": [{"name": "night", "start": "22:00", "end": "06:00"}]}],
+//	  "students": [{"unit_id": "vs23cg003", "student_unit_id": "7", "student_id": "..."}],
+//	  "inserts": [{"unit_id": "vs23cg003", "student_unit_id": "7", "fingerprint_data": "..."}],
+//	  "deletes": [{"unit_id": "vs23cg003", "student_unit_id": "8"}]
+//	}
+type MemoryFixture struct {
+	Devices []struct {
+		UnitId              string `json:"unit_id"`
+		Timezone            string `json:"timezone"`
+		InstitutionTimezone string `json:"institution_timezone"`
+		ScanDebounceSeconds *int   `json:"scan_debounce_seconds"`
+		DayStart            string `json:"day_start"`
+		Shifts              []struct {
+			Name  string `json:"name"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"shifts"`
+		Encoding        string `json:"encoding"`
+		ProtocolVersion uint8  `json:"protocol_version"`
+	} `json:"devices"`
+	Students []struct {
+		UnitId        string `json:"unit_id"`
+		StudentUnitId string `json:"student_unit_id"`
+		StudentId     string `json:"student_id"`
+	} `json:"students"`
+	Inserts []struct {
+		UnitId          string `json:"unit_id"`
+		StudentUnitId   string `json:"student_unit_id"`
+		FingerprintData string `json:"fingerprint_data"`
+	} `json:"inserts"`
+	Deletes []struct {
+		UnitId        string `json:"unit_id"`
+		StudentUnitId string `json:"student_unit_id"`
+	} `json:"deletes"`
+}
+
+func LoadMemoryFixture(path string) (*MemoryFixture, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := new(MemoryFixture)
+
+	if err := json.Unmarshal(content, fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture: %w", err)
+	}
+
+	return fixture, nil
+}
+
+type memoryDevice struct {
+	online          bool
+	encoding        string
+	protocolVersion uint8
+	tenant          string
+	site            string
+	settings        models.DeviceSettings
+}
+
+type memoryInsert struct {
+	studentUnitId   string
+	fingerprintData string
+	quarantined     bool
+	chunkSize       int
+	nextChunk       int
+}
+
+// memoryRepository keeps the device tables in memory for replays and local runs
+// without a database. It follows the postgres repository closely, including
+// pgx.ErrNoRows for missing rows, but stores no outbox events.
+type memoryRepository struct {
+	mu       sync.Mutex
+	devices  map[string]*memoryDevice
+	students map[string]string
+	inserts  map[string][]*memoryInsert
+	deletes  map[string][]string
+	sessions []*models.Attendance
+	punches  map[string]bool
+}
+
+func parseClock(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	clock, err := time.Parse("15:04", value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+func studentKey(unitId string, studentUnitId string) string {
+	return unitId + "/" + studentUnitId
+}
+
+func NewMemoryRepository(fixture *MemoryFixture) (*memoryRepository, error) {
+	repo := &memoryRepository{
+		devices:  make(map[string]*memoryDevice),
+		students: make(map[string]string),
+		inserts:  make(map[string][]*memoryInsert),
+		deletes:  make(map[string][]string),
+		punches:  make(map[string]bool),
+	}
+
+	if fixture == nil {
+		return repo, nil
+	}
+
+	for _, d := range fixture.Devices {
+		device := &memoryDevice{
+			encoding:        d.Encoding,
+			protocolVersion: d.ProtocolVersion,
+			settings: models.DeviceSettings{
+				Timezone:            d.Timezone,
+				InstitutionTimezone: d.InstitutionTimezone,
+			},
+		}
+
+		if device.settings.Timezone == "" {
+			device.settings.Timezone = "UTC"
+		}
+
+		if device.settings.InstitutionTimezone == "" {
+			device.settings.InstitutionTimezone = device.settings.Timezone
+		}
+
+		if d.ScanDebounceSeconds != nil {
+			device.settings.ScanDebounce = time.Duration(*d.ScanDebounceSeconds) * time.Second
+			device.settings.HasScanDebounce = true
+		}
+
+		dayStart, err := parseClock(d.DayStart)
+
+		if err != nil {
+			return nil, err
+		}
+
+		device.settings.DayStart = dayStart
+
+		for _, s := range d.Shifts {
+			start, err := parseClock(s.Start)
+
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := parseClock(s.End)
+
+			if err != nil {
+				return nil, err
+			}
+
+			device.settings.Shifts = append(device.settings.Shifts, models.Shift{Name: s.Name, Start: start, End: end})
+		}
+
+		repo.devices[d.UnitId] = device
+	}
+
+	for _, s := range fixture.Students {
+		repo.students[studentKey(s.UnitId, s.StudentUnitId)] = s.StudentId
+	}
+
+	for _, i := range fixture.Inserts {
+		repo.inserts[i.UnitId] = append(repo.inserts[i.UnitId], &memoryInsert{
+			studentUnitId:   i.StudentUnitId,
+			fingerprintData: i.FingerprintData,
+		})
+	}
+
+	for _, d := range fixture.Deletes {
+		repo.deletes[d.UnitId] = append(repo.deletes[d.UnitId], d.StudentUnitId)
+	}
+
+	return repo, nil
+}
+
+func (repo *memoryRepository) CheckDeviceExists(deviceId string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	_, ok := repo.devices[deviceId]
+	return ok, nil
+}
+
+func (repo *memoryRepository) UpdateDeviceStatus(deviceId string, status bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		device.online = status
+	}
+	return nil
+}
+
+func (repo *memoryRepository) GetDeviceEncoding(deviceId string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		return device.encoding, nil
+	}
+	return "", nil
+}
+
+func (repo *memoryRepository) SetDeviceEncoding(deviceId string, encoding string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		device.encoding = encoding
+	}
+	return nil
+}
+
+func (repo *memoryRepository) GetDeviceProtocolVersion(deviceId string) (uint8, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		return device.protocolVersion, nil
+	}
+	return 0, nil
+}
+
+func (repo *memoryRepository) SetDeviceProtocolVersion(deviceId string, version uint8) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		device.protocolVersion = version
+	}
+	return nil
+}
+
+func (repo *memoryRepository) GetDeviceTopicRoute(deviceId string) (string, string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		return device.tenant, device.site, nil
+	}
+	return "", "", nil
+}
+
+func (repo *memoryRepository) SetDeviceTopicRoute(deviceId string, tenant string, site string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if device, ok := repo.devices[deviceId]; ok {
+		device.tenant = tenant
+		device.site = site
+	}
+	return nil
+}
+
+func (repo *memoryRepository) TouchDevice(deviceId string) error {
+	return nil
+}
+
+func (repo *memoryRepository) GetDeviceSettings(deviceId string) (*models.DeviceSettings, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	device, ok := repo.devices[deviceId]
+
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+
+	settings := device.settings
+	settings.Shifts = append([]models.Shift(nil), device.settings.Shifts...)
+
+	return &settings, nil
+}
+
+func (repo *memoryRepository) CheckStudentsExistsInDeletes(deviceId string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return len(repo.deletes[deviceId]) > 0, nil
+}
+
+func (repo *memoryRepository) GetStudentFromDeletes(deviceId string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if len(repo.deletes[deviceId]) == 0 {
+		return "", pgx.ErrNoRows
+	}
+	return slices.Min(repo.deletes[deviceId]), nil
+}
+
+func (repo *memoryRepository) DeleteStudentFromDeletes(deviceId string, studentId string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	deletes := repo.deletes[deviceId][:0]
+
+	for _, studentUnitId := range repo.deletes[deviceId] {
+		if studentUnitId != studentId {
+			deletes = append(deletes, studentUnitId)
+		}
+	}
+
+	repo.deletes[deviceId] = deletes
+
+	return nil
+}
+
+// queuedInsert must be called with the lock held.
+func (repo *memoryRepository) queuedInsert(deviceId string, studentId string) *memoryInsert {
+	for _, insert := range repo.inserts[deviceId] {
+		if !insert.quarantined && (studentId == "" || insert.studentUnitId == studentId) {
+			return insert
+		}
+	}
+	return nil
+}
+
+func (repo *memoryRepository) CheckStudentsExistsInInserts(deviceId string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.queuedInsert(deviceId, "") != nil, nil
+}
+
+func (repo *memoryRepository) GetStudentFromInserts(deviceId string) (string, string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var next *memoryInsert
+
+	//in the order of the postgres repository, transfers in progress first
+	for _, insert := range repo.inserts[deviceId] {
+		if insert.quarantined {
+			continue
+		}
+
+		if next == nil || insert.nextChunk > next.nextChunk || (insert.nextChunk == next.nextChunk && insert.studentUnitId < next.studentUnitId) {
+			next = insert
+		}
+	}
+
+	if next == nil {
+		return "", "", pgx.ErrNoRows
+	}
+	return next.studentUnitId, next.fingerprintData, nil
+}
+
+func (repo *memoryRepository) DeleteStudentFromInserts(deviceId string, studentId string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	inserts := repo.inserts[deviceId][:0]
+
+	for _, insert := range repo.inserts[deviceId] {
+		if insert.studentUnitId != studentId {
+			inserts = append(inserts, insert)
+		}
+	}
+
+	repo.inserts[deviceId] = inserts
+
+	return nil
+}
+
+func (repo *memoryRepository) QuarantineInsert(deviceId string, studentId string, reason string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if insert := repo.queuedInsert(deviceId, studentId); insert != nil {
+		insert.quarantined = true
+	}
+	return nil
+}
+
+func (repo *memoryRepository) GetInsertTransfer(deviceId string, studentId string) (*models.ChunkTransfer, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	insert := repo.queuedInsert(deviceId, studentId)
+
+	if insert == nil {
+		return new(models.ChunkTransfer), pgx.ErrNoRows
+	}
+
+	return &models.ChunkTransfer{
+		FingerprintData: insert.fingerprintData,
+		ChunkSize:       insert.chunkSize,
+		NextChunk:       insert.nextChunk,
+	}, nil
+}
+
+func (repo *memoryRepository) UpdateInsertTransfer(deviceId string, studentId string, chunkSize int, nextChunk int) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if insert := repo.queuedInsert(deviceId, studentId); insert != nil {
+		insert.chunkSize = chunkSize
+		insert.nextChunk = nextChunk
+	}
+	return nil
+}
+
+func (repo *memoryRepository) GetPendingSyncCounts(deviceId string) (*models.PendingSync, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	pending := &models.PendingSync{
+		UnitId:  deviceId,
+		Deletes: len(repo.deletes[deviceId]),
+	}
+
+	for _, insert := range repo.inserts[deviceId] {
+		if !insert.quarantined {
+			pending.Inserts++
+		}
+	}
+
+	return pending, nil
+}
+
+func (repo *memoryRepository) GetStudentId(unitId string, studentUnitId string) (string, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	studentId, ok := repo.students[studentKey(unitId, studentUnitId)]
+
+	if !ok {
+		return "", pgx.ErrNoRows
+	}
+	return studentId, nil
+}
+
+func (repo *memoryRepository) GetOpenAttendance(studentId string, since time.Time) (*models.Attendance, bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var open *models.Attendance
+
+	for _, session := range repo.sessions {
+		if session.StudentId != studentId || session.LogoutAt != nil || session.LoginAt.Before(since) {
+			continue
+		}
+
+		if open == nil || session.LoginAt.After(open.LoginAt) {
+			open = session
+		}
+	}
+
+	if open == nil {
+		return nil, false, nil
+	}
+
+	att := *open
+
+	return &att, true, nil
+}
+
+// insertPunch must be called with the lock held, it mirrors the unique key of
+// attendance_punches.
+func (repo *memoryRepository) insertPunch(punch *models.Punch) bool {
+	key := fmt.Sprintf("%v/%v/%v", punch.UnitId, punch.Index, punch.ScannedAt.UnixNano())
+
+	if repo.punches[key] {
+		return false
+	}
+
+	repo.punches[key] = true
+
+	return true
+}
+
+func (repo *memoryRepository) InsertPunch(punch *models.Punch) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.insertPunch(punch), nil
+}
+
+func (repo *memoryRepository) InsertAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.insertAttendanceLog(attendanceLog, punch), nil
+}
+
+func (repo *memoryRepository) insertAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) bool {
+	if !repo.insertPunch(punch) {
+		return false
+	}
+
+	session := *attendanceLog
+	repo.sessions = append(repo.sessions, &session)
+
+	return true
+}
+
+func (repo *memoryRepository) UpdateAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	return repo.updateAttendanceLog(attendanceLog, punch), nil
+}
+
+func (repo *memoryRepository) updateAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) bool {
+	if !repo.insertPunch(punch) {
+		return false
+	}
+
+	for _, session := range repo.sessions {
+		if session.StudentId == attendanceLog.StudentId && session.LoginAt.Equal(attendanceLog.LoginAt) && session.LogoutAt == nil {
+			logoutAt := *attendanceLog.LogoutAt
+			session.LogoutAt = &logoutAt
+		}
+	}
+
+	return true
+}
+
+// WriteAttendance applies a write behind batch under one lock, as the postgres
+// repository applies it in one statement.
+func (repo *memoryRepository) WriteAttendance(writes []models.AttendanceWrite) ([]bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	recorded := make([]bool, len(writes))
+
+	for i, write := range writes {
+		switch write.Event {
+		case models.EventAttendanceLogin:
+			recorded[i] = repo.insertAttendanceLog(write.Attendance, write.Punch)
+		case models.EventAttendanceLogout:
+			recorded[i] = repo.updateAttendanceLog(write.Attendance, write.Punch)
+		default:
+			recorded[i] = repo.insertPunch(write.Punch)
+		}
+	}
+
+	return recorded, nil
+}
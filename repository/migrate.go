@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the advisory lock held while migrating, instances
+// starting together wait for each other instead of applying a migration twice.
+const migrationLockKey = 7305917
+
+func Migrate(dbPool *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	//the advisory lock belongs to a session, so every migration runs on the same connection
+	dbConn, err := dbPool.Acquire(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer dbConn.Release()
+
+	if _, err := dbConn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return err
+	}
+
+	defer dbConn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations ( version TEXT PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now() )`
+
+	if _, err := dbConn.Exec(ctx, query); err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+
+		var applied bool
+
+		query := `SELECT EXISTS ( SELECT 1 FROM schema_migrations WHERE version=$1 )`
+
+		if err := dbConn.QueryRow(ctx, query, version).Scan(&applied); err != nil {
+			return err
+		}
+
+		if applied {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile(name)
+
+		if err != nil {
+			return err
+		}
+
+		tx, err := dbConn.Begin(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, string(script)); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+
+		log.Println("applied migration", version)
+	}
+
+	return nil
+}
@@ -0,0 +1,5 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS institution_timezone TEXT;
+
+ALTER TABLE attendance ADD COLUMN IF NOT EXISTS login_at TIMESTAMPTZ;
+ALTER TABLE attendance ADD COLUMN IF NOT EXISTS logout_at TIMESTAMPTZ;
@@ -0,0 +1 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS scan_debounce_seconds INTEGER CHECK ( scan_debounce_seconds >= 0 );
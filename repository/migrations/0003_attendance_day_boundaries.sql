@@ -0,0 +1,11 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS day_start TIME NOT NULL DEFAULT '00:00';
+
+CREATE TABLE IF NOT EXISTS attendance_shifts (
+    unit_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    starts_at TIME NOT NULL,
+    ends_at TIME NOT NULL,
+    PRIMARY KEY (unit_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS attendance_open_sessions_idx ON attendance (student_id, login_at) WHERE logout = '25:00';
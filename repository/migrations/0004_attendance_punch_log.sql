@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS attendance_punches (
+    id BIGSERIAL PRIMARY KEY,
+    unit_id TEXT NOT NULL,
+    student_unit_id TEXT NOT NULL,
+    student_id TEXT NOT NULL,
+    punch_index BIGINT NOT NULL,
+    scanned_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    business_date DATE NOT NULL,
+    UNIQUE (unit_id, punch_index, scanned_at)
+);
+
+CREATE INDEX IF NOT EXISTS attendance_punches_student_idx ON attendance_punches (student_id, scanned_at);
+CREATE INDEX IF NOT EXISTS attendance_punches_date_idx ON attendance_punches (business_date);
+
+CREATE OR REPLACE FUNCTION attendance_punches_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'attendance_punches is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS attendance_punches_immutable ON attendance_punches;
+
+CREATE TRIGGER attendance_punches_immutable
+    BEFORE UPDATE OR DELETE ON attendance_punches
+    FOR EACH ROW EXECUTE FUNCTION attendance_punches_immutable();
+
+CREATE OR REPLACE VIEW attendance_daily AS
+SELECT
+    student_id,
+    date,
+    COUNT(*) AS sessions,
+    MIN(login_at) AS first_in,
+    MAX(logout_at) AS last_out,
+    COALESCE(EXTRACT(EPOCH FROM SUM(logout_at - login_at)) / 3600, 0) AS total_hours
+FROM attendance
+WHERE login_at IS NOT NULL
+GROUP BY student_id, date;
@@ -0,0 +1,55 @@
+DROP VIEW IF EXISTS attendance_daily;
+DROP INDEX IF EXISTS attendance_open_sessions_idx;
+
+ALTER TABLE attendance RENAME TO attendance_sessions;
+
+ALTER TABLE attendance_sessions ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'punch';
+
+-- sessions without a matching punch predate the punch log and must survive rebuilds
+UPDATE attendance_sessions a SET source = 'legacy'
+WHERE NOT EXISTS (
+    SELECT 1 FROM attendance_punches p WHERE p.student_id = a.student_id AND p.scanned_at = a.login_at
+);
+
+ALTER TABLE attendance_sessions ALTER COLUMN date TYPE DATE USING date::TEXT::DATE;
+ALTER TABLE attendance_sessions ALTER COLUMN login TYPE TIME USING login::TEXT::TIME;
+ALTER TABLE attendance_sessions ALTER COLUMN logout DROP NOT NULL;
+ALTER TABLE attendance_sessions ALTER COLUMN logout TYPE TIME USING NULLIF(logout::TEXT, '25:00')::TIME;
+
+-- legacy rows stored the device wall clock, resolve it through the device of the student
+UPDATE attendance_sessions a SET login_at = ( a.date + a.login ) AT TIME ZONE COALESCE((
+    SELECT b.timezone FROM fingerprintdata f JOIN biometric b ON b.unit_id = f.unit_id WHERE f.student_id = a.student_id LIMIT 1
+), 'UTC')
+WHERE a.login_at IS NULL;
+
+UPDATE attendance_sessions a SET logout_at = ( a.date + a.logout + CASE WHEN a.logout < a.login THEN INTERVAL '1 day' ELSE INTERVAL '0' END ) AT TIME ZONE COALESCE((
+    SELECT b.timezone FROM fingerprintdata f JOIN biometric b ON b.unit_id = f.unit_id WHERE f.student_id = a.student_id LIMIT 1
+), 'UTC')
+WHERE a.logout_at IS NULL AND a.logout IS NOT NULL;
+
+ALTER TABLE attendance_sessions ALTER COLUMN login_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS attendance_sessions_open_idx ON attendance_sessions (student_id, login_at) WHERE logout_at IS NULL;
+CREATE INDEX IF NOT EXISTS attendance_sessions_date_idx ON attendance_sessions (date);
+
+-- compatibility view with the previous text columns and the "25:00" open session marker
+CREATE VIEW attendance AS
+SELECT
+    student_id,
+    to_char(date, 'YYYY-MM-DD') AS date,
+    to_char(login, 'HH24:MI') AS login,
+    COALESCE(to_char(logout, 'HH24:MI'), '25:00') AS logout,
+    login_at,
+    logout_at
+FROM attendance_sessions;
+
+CREATE VIEW attendance_daily AS
+SELECT
+    student_id,
+    date,
+    COUNT(*) AS sessions,
+    MIN(login_at) AS first_in,
+    MAX(logout_at) AS last_out,
+    COALESCE(EXTRACT(EPOCH FROM SUM(logout_at - login_at)) / 3600, 0) AS total_hours
+FROM attendance_sessions
+GROUP BY student_id, date;
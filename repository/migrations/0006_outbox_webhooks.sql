@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id BIGSERIAL PRIMARY KEY,
+    url TEXT NOT NULL UNIQUE,
+    secret TEXT NOT NULL,
+    -- NULL subscribes the endpoint to every event type
+    event_types TEXT[],
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    event_id BIGINT NOT NULL REFERENCES outbox_events (id) ON DELETE CASCADE,
+    endpoint_id BIGINT NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK ( status IN ('pending', 'delivered', 'failed') ),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    PRIMARY KEY (event_id, endpoint_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE OR REPLACE FUNCTION outbox_events_fan_out() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO webhook_deliveries (event_id, endpoint_id)
+    SELECT NEW.id, e.id FROM webhook_endpoints e
+    WHERE e.active AND ( e.event_types IS NULL OR NEW.event_type = ANY(e.event_types) );
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS outbox_events_fan_out ON outbox_events;
+
+CREATE TRIGGER outbox_events_fan_out
+    AFTER INSERT ON outbox_events
+    FOR EACH ROW EXECUTE FUNCTION outbox_events_fan_out();
@@ -0,0 +1,5 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS inserts_unit_id_idx ON inserts (unit_id);
+CREATE INDEX IF NOT EXISTS deletes_unit_id_idx ON deletes (unit_id);
+CREATE INDEX IF NOT EXISTS attendance_punches_unit_idx ON attendance_punches (unit_id, received_at DESC);
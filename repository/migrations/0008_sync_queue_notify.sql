@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION sync_queue_notify() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('sync_queue', NEW.unit_id);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS inserts_sync_queue_notify ON inserts;
+
+CREATE TRIGGER inserts_sync_queue_notify
+    AFTER INSERT ON inserts
+    FOR EACH ROW EXECUTE FUNCTION sync_queue_notify();
+
+DROP TRIGGER IF EXISTS deletes_sync_queue_notify ON deletes;
+
+CREATE TRIGGER deletes_sync_queue_notify
+    AFTER INSERT ON deletes
+    FOR EACH ROW EXECUTE FUNCTION sync_queue_notify();
@@ -0,0 +1,4 @@
+ALTER TABLE inserts ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMPTZ;
+ALTER TABLE inserts ADD COLUMN IF NOT EXISTS quarantine_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS inserts_quarantined_idx ON inserts (unit_id) WHERE quarantined_at IS NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE inserts ADD COLUMN IF NOT EXISTS chunk_size INTEGER;
+ALTER TABLE inserts ADD COLUMN IF NOT EXISTS chunk_next INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE inserts ADD COLUMN IF NOT EXISTS fingerprint_key_id TEXT;
+ALTER TABLE fingerprintdata ADD COLUMN IF NOT EXISTS fingerprint_key_id TEXT;
@@ -0,0 +1 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS protocol_version SMALLINT;
@@ -0,0 +1,62 @@
+CREATE OR REPLACE FUNCTION device_registry_notify() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_LEVEL = 'STATEMENT' THEN
+        PERFORM pg_notify('device_registry', '');
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('device_registry', OLD.unit_id);
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('device_registry', NEW.unit_id);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION student_mapping_notify() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_LEVEL = 'STATEMENT' THEN
+        PERFORM pg_notify('student_mapping', '');
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('student_mapping', OLD.unit_id || '/' || OLD.student_unit_id);
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('student_mapping', NEW.unit_id || '/' || NEW.student_unit_id);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- online and last seen updates happen on every message and do not change the registry
+DROP TRIGGER IF EXISTS biometric_registry_notify ON biometric;
+
+CREATE TRIGGER biometric_registry_notify
+    AFTER INSERT OR DELETE OR UPDATE OF unit_id ON biometric
+    FOR EACH ROW EXECUTE FUNCTION device_registry_notify();
+
+DROP TRIGGER IF EXISTS biometric_registry_truncate_notify ON biometric;
+
+CREATE TRIGGER biometric_registry_truncate_notify
+    AFTER TRUNCATE ON biometric
+    FOR EACH STATEMENT EXECUTE FUNCTION device_registry_notify();
+
+DROP TRIGGER IF EXISTS fingerprintdata_mapping_notify ON fingerprintdata;
+
+CREATE TRIGGER fingerprintdata_mapping_notify
+    AFTER INSERT OR DELETE OR UPDATE OF unit_id, student_unit_id, student_id ON fingerprintdata
+    FOR EACH ROW EXECUTE FUNCTION student_mapping_notify();
+
+DROP TRIGGER IF EXISTS fingerprintdata_mapping_truncate_notify ON fingerprintdata;
+
+CREATE TRIGGER fingerprintdata_mapping_truncate_notify
+    AFTER TRUNCATE ON fingerprintdata
+    FOR EACH STATEMENT EXECUTE FUNCTION student_mapping_notify();
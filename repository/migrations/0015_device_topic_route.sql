@@ -0,0 +1,2 @@
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS topic_tenant TEXT;
+ALTER TABLE biometric ADD COLUMN IF NOT EXISTS topic_site TEXT;
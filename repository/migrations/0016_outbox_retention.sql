@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS outbox_events_created_at_idx ON outbox_events (created_at);
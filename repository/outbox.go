@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type deviceStatusPayload struct {
+	UnitId string `json:"unit_id"`
+	Online bool   `json:"online"`
+}
+
+type attendancePayload struct {
+	StudentId     string     `json:"student_id"`
+	UnitId        string     `json:"unit_id"`
+	StudentUnitId string     `json:"student_unit_id"`
+	Index         uint32     `json:"index"`
+	Date          string     `json:"date"`
+	LoginAt       time.Time  `json:"login_at"`
+	LogoutAt      *time.Time `json:"logout_at,omitempty"`
+}
+
+type enrollmentPayload struct {
+	UnitId        string `json:"unit_id"`
+	StudentUnitId string `json:"student_unit_id"`
+}
+
+// insertOutboxEvent must run in the transaction of the state change it describes.
+func insertOutboxEvent(ctx context.Context, tx pgx.Tx, eventType string, payload any) error {
+	payloadJson, err := json.Marshal(payload)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox_events (event_type,payload) VALUES ($1,$2)`
+	_, err = tx.Exec(ctx, query, eventType, payloadJson)
+	return err
+}
+
+func newAttendancePayload(attendanceLog *models.Attendance, punch *models.Punch) attendancePayload {
+	return attendancePayload{
+		StudentId:     attendanceLog.StudentId,
+		UnitId:        punch.UnitId,
+		StudentUnitId: punch.StudentUnitId,
+		Index:         punch.Index,
+		Date:          attendanceLog.Date.Format("2006-01-02"),
+		LoginAt:       attendanceLog.LoginAt,
+		LogoutAt:      attendanceLog.LogoutAt,
+	}
+}
+
+// ClaimWebhookDeliveries leases due deliveries by pushing their next attempt past the
+// lease, so that a crashed dispatcher only delays them. Deliveries of deactivated
+// endpoints stay pending and resume when the endpoint is activated again.
+func (repo *postgresRepository) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	query := `WITH due AS (
+			SELECT d.event_id, d.endpoint_id FROM webhook_deliveries d
+			JOIN webhook_endpoints e ON e.id = d.endpoint_id AND e.active
+			WHERE d.status='pending' AND d.next_attempt_at <= now()
+			ORDER BY d.next_attempt_at LIMIT $1 FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d SET next_attempt_at = now() + $2::INTERVAL
+		FROM due, webhook_endpoints e, outbox_events o
+		WHERE d.event_id = due.event_id AND d.endpoint_id = due.endpoint_id AND e.id = d.endpoint_id AND o.id = d.event_id
+		RETURNING d.event_id, d.endpoint_id, e.url, e.secret, o.event_type, o.payload::TEXT, o.created_at, d.attempts`
+
+	rows, err := repo.dbConn.Query(context.Background(), query, limit, lease)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var payload string
+
+		if err := rows.Scan(
+			&delivery.EventId,
+			&delivery.EndpointId,
+			&delivery.Url,
+			&delivery.Secret,
+			&delivery.EventType,
+			&payload,
+			&delivery.CreatedAt,
+			&delivery.Attempts,
+		); err != nil {
+			return nil, err
+		}
+
+		delivery.Payload = []byte(payload)
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (repo *postgresRepository) MarkWebhookDelivered(eventId int64, endpointId int64, statusCode int) error {
+	query := `UPDATE webhook_deliveries SET status='delivered', attempts=attempts+1, last_status_code=$3, last_error=NULL, delivered_at=now()
+		WHERE event_id=$1 AND endpoint_id=$2`
+	_, err := repo.dbConn.Exec(context.Background(), query, eventId, endpointId, statusCode)
+	return err
+}
+
+// MarkWebhookFailed schedules the next attempt, or gives up when nextAttemptAt is nil.
+func (repo *postgresRepository) MarkWebhookFailed(eventId int64, endpointId int64, statusCode int, reason string, nextAttemptAt *time.Time) error {
+	var code *int
+
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	if nextAttemptAt == nil {
+		query := `UPDATE webhook_deliveries SET status='failed', attempts=attempts+1, last_status_code=$3, last_error=$4
+			WHERE event_id=$1 AND endpoint_id=$2`
+		_, err := repo.dbConn.Exec(context.Background(), query, eventId, endpointId, code, reason)
+		return err
+	}
+
+	query := `UPDATE webhook_deliveries SET attempts=attempts+1, last_status_code=$3, last_error=$4, next_attempt_at=$5
+		WHERE event_id=$1 AND endpoint_id=$2`
+	_, err := repo.dbConn.Exec(context.Background(), query, eventId, endpointId, code, reason, *nextAttemptAt)
+	return err
+}
+
+// PruneDeliveredWebhookEvents deletes up to limit events older than olderThan whose
+// deliveries all succeeded, their deliveries go with them. Events with a pending or
+// failed delivery are kept for retries and inspection.
+func (repo *postgresRepository) PruneDeliveredWebhookEvents(olderThan time.Duration, limit int) (int64, error) {
+	query := `DELETE FROM outbox_events WHERE id IN (
+			SELECT o.id FROM outbox_events o
+			WHERE o.created_at < now() - $1::INTERVAL
+			AND NOT EXISTS ( SELECT 1 FROM webhook_deliveries d WHERE d.event_id = o.id AND d.status <> 'delivered' )
+			ORDER BY o.created_at LIMIT $2
+		)`
+
+	tag, err := repo.dbConn.Exec(context.Background(), query, olderThan, limit)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -2,18 +2,28 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vithsutra/biometric-project-message-processor/encryption"
 	"github.com/vithsutra/biometric-project-message-processor/models"
 )
 
 type postgresRepository struct {
-	dbConn *pgxpool.Pool
+	dbConn           *pgxpool.Pool
+	templateEnvelope *encryption.Envelope
 }
 
-func NewPostgresRepository(dbConn *pgxpool.Pool) *postgresRepository {
+// NewPostgresRepository takes a nil envelope when no encryption keys are configured,
+// templates that were stored encrypted then fail to load.
+func NewPostgresRepository(dbConn *pgxpool.Pool, templateEnvelope *encryption.Envelope) *postgresRepository {
 	return &postgresRepository{
 		dbConn,
+		templateEnvelope,
 	}
 }
 
@@ -25,11 +35,163 @@ func (repo *postgresRepository) CheckDeviceExists(deviceId string) (bool, error)
 }
 
 func (repo *postgresRepository) UpdateDeviceStatus(deviceId string, status bool) error {
-	query := `UPDATE biometric SET online=$2 WHERE unit_id=$1`
-	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, status)
+	ctx := context.Background()
+
+	tx, err := repo.dbConn.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE biometric SET online=$2 WHERE unit_id=$1 AND online IS DISTINCT FROM $2`
+
+	tag, err := tx.Exec(ctx, query, deviceId, status)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 1 {
+		eventType := models.EventDeviceDisconnected
+
+		if status {
+			eventType = models.EventDeviceConnected
+		}
+
+		if err := insertOutboxEvent(ctx, tx, eventType, deviceStatusPayload{UnitId: deviceId, Online: status}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (repo *postgresRepository) GetDeviceEncoding(deviceId string) (string, error) {
+	query := `SELECT COALESCE(payload_encoding, '') FROM biometric WHERE unit_id=$1`
+	var encoding string
+	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&encoding)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+
+	return encoding, err
+}
+
+func (repo *postgresRepository) SetDeviceEncoding(deviceId string, encoding string) error {
+	query := `UPDATE biometric SET payload_encoding=$2 WHERE unit_id=$1`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, encoding)
+	return err
+}
+
+func (repo *postgresRepository) GetDeviceProtocolVersion(deviceId string) (uint8, error) {
+	query := `SELECT COALESCE(protocol_version, 0) FROM biometric WHERE unit_id=$1`
+	var version int16
+	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&version)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+
+	return uint8(version), err
+}
+
+func (repo *postgresRepository) SetDeviceProtocolVersion(deviceId string, version uint8) error {
+	query := `UPDATE biometric SET protocol_version=$2 WHERE unit_id=$1`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, int16(version))
 	return err
 }
 
+// GetDeviceTopicRoute returns the tenant and site of the topic the device last
+// connected on.
+func (repo *postgresRepository) GetDeviceTopicRoute(deviceId string) (string, string, error) {
+	query := `SELECT COALESCE(topic_tenant, ''), COALESCE(topic_site, '') FROM biometric WHERE unit_id=$1`
+	var tenant, site string
+	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&tenant, &site)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+
+	return tenant, site, err
+}
+
+func (repo *postgresRepository) SetDeviceTopicRoute(deviceId string, tenant string, site string) error {
+	query := `UPDATE biometric SET topic_tenant=$2, topic_site=$3 WHERE unit_id=$1`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, tenant, site)
+	return err
+}
+
+// GetDeviceSettings reads the device row and its shifts in one batch.
+func (repo *postgresRepository) GetDeviceSettings(deviceId string) (*models.DeviceSettings, error) {
+	ctx := context.Background()
+
+	settings := new(models.DeviceSettings)
+
+	err := repo.prepared(ctx, func(conn *pgx.Conn) error {
+		batch := new(pgx.Batch)
+		batch.Queue(stmtGetDeviceSettings, deviceId)
+		batch.Queue(stmtGetDeviceShifts, deviceId)
+
+		results := conn.SendBatch(ctx, batch)
+
+		defer results.Close()
+
+		var debounceSeconds *int32
+		var dayStartSeconds int64
+
+		err := results.QueryRow().Scan(
+			&settings.Timezone,
+			&settings.InstitutionTimezone,
+			&debounceSeconds,
+			&dayStartSeconds,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if debounceSeconds != nil {
+			settings.ScanDebounce = time.Duration(*debounceSeconds) * time.Second
+			settings.HasScanDebounce = true
+		}
+
+		settings.DayStart = time.Duration(dayStartSeconds) * time.Second
+
+		rows, err := results.Query()
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var shift models.Shift
+			var startSeconds, endSeconds int64
+
+			if err := rows.Scan(&shift.Name, &startSeconds, &endSeconds); err != nil {
+				return err
+			}
+
+			shift.Start = time.Duration(startSeconds) * time.Second
+			shift.End = time.Duration(endSeconds) * time.Second
+
+			settings.Shifts = append(settings.Shifts, shift)
+		}
+
+		return rows.Err()
+	}, stmtGetDeviceSettings, stmtGetDeviceShifts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
 func (repo *postgresRepository) CheckStudentsExistsInDeletes(deviceId string) (bool, error) {
 	query := `SELECT EXISTS ( SELECT 1 FROM deletes WHERE unit_id=$1 )`
 	var exists bool
@@ -38,76 +200,460 @@ func (repo *postgresRepository) CheckStudentsExistsInDeletes(deviceId string) (b
 }
 
 func (repo *postgresRepository) GetStudentFromDeletes(deviceId string) (string, error) {
-	query := `SELECT student_unit_id FROM deletes WHERE unit_id=$1`
+	query := `SELECT student_unit_id FROM deletes WHERE unit_id=$1 ORDER BY student_unit_id LIMIT 1`
 	var id string
 	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&id)
 	return id, err
 }
 
 func (repo *postgresRepository) DeleteStudentFromDeletes(deviceId string, studentId string) error {
-	query := `DELETE FROM deletes WHERE unit_id=$1 AND student_unit_id=$2`
-	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, studentId)
-	return err
+	return repo.deleteSyncedStudent(stmtDeleteStudentDeletes, models.EventEnrollmentRemoved, deviceId, studentId)
 }
 
 func (repo *postgresRepository) CheckStudentsExistsInInserts(deviceId string) (bool, error) {
-	query := `SELECT EXISTS ( SELECT 1 FROM inserts WHERE unit_id=$1 )`
+	query := `SELECT EXISTS ( SELECT 1 FROM inserts WHERE unit_id=$1 AND quarantined_at IS NULL )`
 	var exists bool
 	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&exists)
 	return exists, err
 }
 
+// GetStudentFromInserts returns the next template to sync to a device. A transfer that
+// already sent chunks comes first so a device resumes the student it was receiving,
+// the rest follow in the order of their student unit ids.
 func (repo *postgresRepository) GetStudentFromInserts(deviceId string) (string, string, error) {
-	query := `SELECT student_unit_id,fingerprint_data FROM inserts WHERE unit_id=$1`
+	query := `SELECT student_unit_id,fingerprint_data,fingerprint_key_id FROM inserts WHERE unit_id=$1 AND quarantined_at IS NULL
+		ORDER BY chunk_next DESC, student_unit_id LIMIT 1`
 	var id, fingerprint string
-	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&id, &fingerprint)
+	var keyId *string
+
+	if err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&id, &fingerprint, &keyId); err != nil {
+		return id, fingerprint, err
+	}
+
+	fingerprint, err := repo.decryptTemplate(deviceId, id, fingerprint, keyId)
 	return id, fingerprint, err
 }
 
 func (repo *postgresRepository) DeleteStudentFromInserts(deviceId string, studentId string) error {
-	query := `DELETE FROM inserts WHERE unit_id=$1 AND student_unit_id=$2`
-	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, studentId)
+	return repo.deleteSyncedStudent(stmtDeleteStudentInserts, models.EventEnrollmentSynced, deviceId, studentId)
+}
+
+// deleteSyncedStudent removes a synced row and records its event in one round trip,
+// the event is only written when a row was removed.
+func (repo *postgresRepository) deleteSyncedStudent(statement string, eventType string, deviceId string, studentId string) error {
+	ctx := context.Background()
+
+	payload, err := json.Marshal(enrollmentPayload{UnitId: deviceId, StudentUnitId: studentId})
+
+	if err != nil {
+		return err
+	}
+
+	return repo.prepared(ctx, func(conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, statement, deviceId, studentId, eventType, payload)
+		return err
+	}, statement)
+}
+
+func (repo *postgresRepository) QuarantineInsert(deviceId string, studentId string, reason string) error {
+	query := `UPDATE inserts SET quarantined_at=now(), quarantine_reason=$3 WHERE unit_id=$1 AND student_unit_id=$2 AND quarantined_at IS NULL`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, studentId, reason)
+	return err
+}
+
+func (repo *postgresRepository) GetInsertTransfer(deviceId string, studentId string) (*models.ChunkTransfer, error) {
+	query := `SELECT fingerprint_data, fingerprint_key_id, COALESCE(chunk_size, 0), chunk_next FROM inserts WHERE unit_id=$1 AND student_unit_id=$2 AND quarantined_at IS NULL LIMIT 1`
+	transfer := new(models.ChunkTransfer)
+	var keyId *string
+
+	if err := repo.dbConn.QueryRow(context.Background(), query, deviceId, studentId).Scan(&transfer.FingerprintData, &keyId, &transfer.ChunkSize, &transfer.NextChunk); err != nil {
+		return transfer, err
+	}
+
+	fingerprint, err := repo.decryptTemplate(deviceId, studentId, transfer.FingerprintData, keyId)
+	transfer.FingerprintData = fingerprint
+	return transfer, err
+}
+
+func (repo *postgresRepository) UpdateInsertTransfer(deviceId string, studentId string, chunkSize int, nextChunk int) error {
+	query := `UPDATE inserts SET chunk_size=$3, chunk_next=$4 WHERE unit_id=$1 AND student_unit_id=$2 AND quarantined_at IS NULL`
+	_, err := repo.dbConn.Exec(context.Background(), query, deviceId, studentId, chunkSize, nextChunk)
 	return err
 }
 
 func (repo *postgresRepository) GetStudentId(unitId string, studentUnitId string) (string, error) {
-	query := `SELECT student_id FROM fingerprintdata WHERE unit_id=$1 AND student_unit_id=$2`
+	ctx := context.Background()
 	var studentId string
-	err := repo.dbConn.QueryRow(context.Background(), query, unitId, studentUnitId).Scan(&studentId)
+	err := repo.prepared(ctx, func(conn *pgx.Conn) error {
+		return conn.QueryRow(ctx, stmtGetStudentId, unitId, studentUnitId).Scan(&studentId)
+	}, stmtGetStudentId)
 	return studentId, err
 }
 
-func (repo *postgresRepository) CheckLoginOrLogout(studentId string, date string) (bool, error) {
-	query := `SELECT EXISTS ( SELECT 1 FROM attendance WHERE date=$1 AND student_id=$2 and logout=$3 )`
-	var logStatus bool
-	err := repo.dbConn.QueryRow(context.Background(), query, date, studentId, "25:00").Scan(&logStatus)
-	return logStatus, err
+// GetOpenAttendance finds the open session of a student that started after since,
+// regardless of its date, so that overnight sessions can be closed.
+func (repo *postgresRepository) GetOpenAttendance(studentId string, since time.Time) (*models.Attendance, bool, error) {
+	ctx := context.Background()
+
+	att := new(models.Attendance)
+
+	err := repo.prepared(ctx, func(conn *pgx.Conn) error {
+		return conn.QueryRow(ctx, stmtGetOpenAttendance, studentId, since).Scan(&att.Date, &att.LoginAt)
+	}, stmtGetOpenAttendance)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	att.StudentId = studentId
+
+	return att, true, nil
 }
 
-func (repo *postgresRepository) InsertAttendanceLog(attendanceLog *models.Attendance) error {
-	query := `INSERT INTO attendance (student_id,date,login,logout) VALUES ($1,$2,$3,$4)`
+func wallClock(t time.Time) pgtype.Time {
+	hour, minute, second := t.Clock()
 
-	_, err := repo.dbConn.Exec(
-		context.Background(),
-		query,
+	return pgtype.Time{
+		Microseconds: int64(hour)*3600_000_000 + int64(minute)*60_000_000 + int64(second)*1_000_000,
+		Valid:        true,
+	}
+}
+
+func punchArguments(punch *models.Punch) []any {
+	return []any{
+		punch.UnitId,
+		punch.StudentUnitId,
+		punch.StudentId,
+		punch.Index,
+		punch.ScannedAt,
+		punch.ReceivedAt,
+		punch.BusinessDate,
+	}
+}
+
+// execPunch runs one of the punch statements, the punch was new when a row was written.
+func (repo *postgresRepository) execPunch(statement string, arguments ...any) (bool, error) {
+	ctx := context.Background()
+
+	var inserted bool
+
+	err := repo.prepared(ctx, func(conn *pgx.Conn) error {
+		tag, err := conn.Exec(ctx, statement, arguments...)
+		inserted = tag.RowsAffected() == 1
+		return err
+	}, statement)
+
+	return inserted, err
+}
+
+// InsertPunch stores a scan that does not change any session. It returns false when
+// the device already delivered the same punch.
+func (repo *postgresRepository) InsertPunch(punch *models.Punch) (bool, error) {
+	return repo.execPunch(stmtInsertPunch, punchArguments(punch)...)
+}
+
+// InsertAttendanceLog opens a session and stores its punch and login event in a single statement.
+// A redelivered punch leaves the session untouched and returns false.
+func (repo *postgresRepository) InsertAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	payload, err := json.Marshal(newAttendancePayload(attendanceLog, punch))
+
+	if err != nil {
+		return false, err
+	}
+
+	return repo.execPunch(stmtInsertAttendanceLog, append(
+		punchArguments(punch),
 		attendanceLog.StudentId,
 		attendanceLog.Date,
-		attendanceLog.Login,
-		attendanceLog.Logout,
-	)
+		wallClock(attendanceLog.LoginAt),
+		attendanceLog.LoginAt,
+		models.EventAttendanceLogin,
+		payload,
+	)...)
+}
 
-	return err
+// UpdateAttendanceLog closes the open session started at attendanceLog.LoginAt and stores its
+// punch and logout event in a single statement. A redelivered punch returns false.
+func (repo *postgresRepository) UpdateAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	payload, err := json.Marshal(newAttendancePayload(attendanceLog, punch))
+
+	if err != nil {
+		return false, err
+	}
+
+	return repo.execPunch(stmtUpdateAttendanceLog, append(
+		punchArguments(punch),
+		attendanceLog.StudentId,
+		attendanceLog.LoginAt,
+		wallClock(*attendanceLog.LogoutAt),
+		attendanceLog.LogoutAt,
+		models.EventAttendanceLogout,
+		payload,
+	)...)
 }
 
-func (repo *postgresRepository) UpdateAttendanceLog(studentId string, date string, logout string) error {
-	query := `UPDATE attendance SET logout=$4 WHERE student_id=$1 AND date=$2 AND logout=$3`
-	_, err := repo.dbConn.Exec(
-		context.Background(),
-		query,
-		studentId,
-		date,
-		"25:00",
-		logout,
+type punchKey struct {
+	unitId    string
+	index     uint32
+	scannedAt int64
+}
+
+// WriteAttendance stores a write behind batch in a single statement. A punch that is
+// repeated within the batch is written once, its repeats report false like a punch
+// that was already stored.
+//
+// The rows are sent as arrays that unnest expands into a multi-row insert rather than
+// with COPY. COPY can not skip punches that are already stored, which needs ON CONFLICT,
+// so it would need a staging table and three more round trips for every batch, while
+// batches are bounded by the number of workers.
+func (repo *postgresRepository) WriteAttendance(writes []models.AttendanceWrite) ([]bool, error) {
+	var (
+		records        []int32
+		unitIds        []string
+		studentUnitIds []string
+		studentIds     []string
+		indexes        []int64
+		scannedAts     []time.Time
+		receivedAts    []time.Time
+		businessDates  []time.Time
+		eventTypes     []pgtype.Text
+		sessionDates   []pgtype.Date
+		loginAts       []pgtype.Timestamptz
+		clocks         []pgtype.Time
+		logoutAts      []pgtype.Timestamptz
+		payloads       [][]byte
 	)
-	return err
+
+	seen := make(map[punchKey]bool, len(writes))
+
+	for i, write := range writes {
+		punch := write.Punch
+		key := punchKey{unitId: punch.UnitId, index: punch.Index, scannedAt: punch.ScannedAt.UnixMicro()}
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		var (
+			eventType   pgtype.Text
+			sessionDate pgtype.Date
+			loginAt     pgtype.Timestamptz
+			clock       pgtype.Time
+			logoutAt    pgtype.Timestamptz
+			payload     []byte
+		)
+
+		if write.Event != "" {
+			attendanceLog := write.Attendance
+
+			eventType = pgtype.Text{String: write.Event, Valid: true}
+			sessionDate = pgtype.Date{Time: attendanceLog.Date, Valid: true}
+			loginAt = pgtype.Timestamptz{Time: attendanceLog.LoginAt, Valid: true}
+			clock = wallClock(attendanceLog.LoginAt)
+
+			if write.Event == models.EventAttendanceLogout {
+				logoutAt = pgtype.Timestamptz{Time: *attendanceLog.LogoutAt, Valid: true}
+				clock = wallClock(*attendanceLog.LogoutAt)
+			}
+
+			var err error
+
+			if payload, err = json.Marshal(newAttendancePayload(attendanceLog, punch)); err != nil {
+				return nil, err
+			}
+		}
+
+		records = append(records, int32(i))
+		unitIds = append(unitIds, punch.UnitId)
+		studentUnitIds = append(studentUnitIds, punch.StudentUnitId)
+		studentIds = append(studentIds, punch.StudentId)
+		indexes = append(indexes, int64(punch.Index))
+		scannedAts = append(scannedAts, punch.ScannedAt)
+		receivedAts = append(receivedAts, punch.ReceivedAt)
+		businessDates = append(businessDates, punch.BusinessDate)
+		eventTypes = append(eventTypes, eventType)
+		sessionDates = append(sessionDates, sessionDate)
+		loginAts = append(loginAts, loginAt)
+		clocks = append(clocks, clock)
+		logoutAts = append(logoutAts, logoutAt)
+		payloads = append(payloads, payload)
+	}
+
+	recorded := make([]bool, len(writes))
+
+	ctx := context.Background()
+
+	err := repo.prepared(ctx, func(conn *pgx.Conn) error {
+		rows, err := conn.Query(ctx, stmtWriteAttendance,
+			records,
+			unitIds,
+			studentUnitIds,
+			studentIds,
+			indexes,
+			scannedAts,
+			receivedAts,
+			businessDates,
+			eventTypes,
+			sessionDates,
+			loginAts,
+			clocks,
+			logoutAts,
+			payloads,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var record int32
+
+			if err := rows.Scan(&record); err != nil {
+				return err
+			}
+
+			recorded[record] = true
+		}
+
+		return rows.Err()
+	}, stmtWriteAttendance)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded, nil
+}
+
+func (repo *postgresRepository) GetPunches(fromDate time.Time, toDate time.Time) ([]models.Punch, error) {
+	query := `SELECT unit_id, student_unit_id, student_id, punch_index, scanned_at, received_at, business_date
+		FROM attendance_punches WHERE business_date BETWEEN $1 AND $2 ORDER BY student_id, scanned_at, id`
+
+	rows, err := repo.dbConn.Query(context.Background(), query, fromDate, toDate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var punches []models.Punch
+
+	for rows.Next() {
+		var punch models.Punch
+		var index int64
+
+		if err := rows.Scan(
+			&punch.UnitId,
+			&punch.StudentUnitId,
+			&punch.StudentId,
+			&index,
+			&punch.ScannedAt,
+			&punch.ReceivedAt,
+			&punch.BusinessDate,
+		); err != nil {
+			return nil, err
+		}
+
+		punch.Index = uint32(index)
+
+		punches = append(punches, punch)
+	}
+
+	return punches, rows.Err()
+}
+
+// GetSessionsOpenAt returns the sessions dated before fromDate that logged in at or
+// after since and were still open at fromDate: without a logout, or closed by a
+// punch of fromDate or later.
+func (repo *postgresRepository) GetSessionsOpenAt(fromDate time.Time, since time.Time) ([]models.Attendance, error) {
+	query := `SELECT s.student_id, s.date, s.login_at FROM attendance_sessions s
+		WHERE s.date < $1 AND s.login_at >= $2 AND s.source = 'punch' AND ( s.logout_at IS NULL OR EXISTS (
+			SELECT 1 FROM attendance_punches p WHERE p.student_id = s.student_id AND p.scanned_at = s.logout_at AND p.business_date >= $1 ) )`
+
+	rows, err := repo.dbConn.Query(context.Background(), query, fromDate, since)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sessions []models.Attendance
+
+	for rows.Next() {
+		var session models.Attendance
+
+		if err := rows.Scan(&session.StudentId, &session.Date, &session.LoginAt); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// ReplaceAttendance swaps the sessions of the given students in the date range for
+// sessions derived from the punch log. Rows that predate the punch log are kept.
+// Sessions dated before fromDate were open at fromDate, their logout is updated.
+func (repo *postgresRepository) ReplaceAttendance(fromDate time.Time, toDate time.Time, studentIds []string, sessions []models.Attendance) error {
+	ctx := context.Background()
+
+	tx, err := repo.dbConn.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	query := `DELETE FROM attendance_sessions WHERE date BETWEEN $1 AND $2 AND student_id = ANY($3) AND source=$4`
+
+	if _, err := tx.Exec(ctx, query, fromDate, toDate, studentIds, "punch"); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO attendance_sessions (student_id,date,login,logout,login_at,logout_at) VALUES ($1,$2,$3,$4,$5,$6)`
+
+	seedQuery := `UPDATE attendance_sessions SET logout=$3, logout_at=$4 WHERE student_id=$1 AND login_at=$2 AND source='punch'`
+
+	batch := new(pgx.Batch)
+
+	for _, session := range sessions {
+		var logout pgtype.Time
+
+		if session.LogoutAt != nil {
+			logout = wallClock(*session.LogoutAt)
+		}
+
+		if session.Date.Before(fromDate) {
+			batch.Queue(seedQuery, session.StudentId, session.LoginAt, logout, session.LogoutAt)
+			continue
+		}
+
+		batch.Queue(
+			query,
+			session.StudentId,
+			session.Date,
+			wallClock(session.LoginAt),
+			logout,
+			session.LoginAt,
+			session.LogoutAt,
+		)
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
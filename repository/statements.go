@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// Named statements of the per message paths. They are prepared on first use on each
+// pooled connection rather than at connect time, so that connections opened before
+// the migrations ran do not fail. Once prepared they cost no more than the statement
+// cache of pgx, the round trips are saved by batching the reads and writing in single
+// statements. BenchmarkAttendanceRoundTrips measures the round trips per message.
+const (
+	stmtGetStudentId         = "get_student_id"
+	stmtGetDeviceSettings    = "get_device_settings"
+	stmtGetDeviceShifts      = "get_device_shifts"
+	stmtGetOpenAttendance    = "get_open_attendance"
+	stmtInsertPunch          = "insert_punch"
+	stmtInsertAttendanceLog  = "insert_attendance_log"
+	stmtUpdateAttendanceLog  = "update_attendance_log"
+	stmtDeleteStudentDeletes = "delete_student_from_deletes"
+	stmtDeleteStudentInserts = "delete_student_from_inserts"
+	stmtWriteAttendance      = "write_attendance"
+)
+
+const punchInsert = `INSERT INTO attendance_punches (unit_id,student_unit_id,student_id,punch_index,scanned_at,received_at,business_date)
+		VALUES ($1,$2,$3,$4,$5,$6,$7) ON CONFLICT (unit_id,punch_index,scanned_at) DO NOTHING`
+
+// The attendance writes store the punch, the session change and the outbox event in
+// one statement, the session and the event only happen when the punch is new.
+var statements = map[string]string{
+	stmtGetStudentId: `SELECT student_id FROM fingerprintdata WHERE unit_id=$1 AND student_unit_id=$2`,
+
+	stmtGetDeviceSettings: `SELECT timezone, COALESCE(institution_timezone, timezone), scan_debounce_seconds, EXTRACT(EPOCH FROM day_start)::BIGINT FROM biometric WHERE unit_id=$1`,
+
+	stmtGetDeviceShifts: `SELECT name, EXTRACT(EPOCH FROM starts_at)::BIGINT, EXTRACT(EPOCH FROM ends_at)::BIGINT FROM attendance_shifts WHERE unit_id=$1 ORDER BY starts_at`,
+
+	stmtGetOpenAttendance: `SELECT date, login_at FROM attendance_sessions
+		WHERE student_id=$1 AND logout_at IS NULL AND login_at >= $2
+		ORDER BY login_at DESC LIMIT 1`,
+
+	stmtInsertPunch: punchInsert,
+
+	stmtInsertAttendanceLog: `WITH punch AS ( ` + punchInsert + ` RETURNING 1 ),
+		session AS ( INSERT INTO attendance_sessions (student_id,date,login,login_at) SELECT $8,$9,$10,$11 WHERE EXISTS ( SELECT 1 FROM punch ) )
+		INSERT INTO outbox_events (event_type,payload) SELECT $12,$13 WHERE EXISTS ( SELECT 1 FROM punch )`,
+
+	stmtUpdateAttendanceLog: `WITH punch AS ( ` + punchInsert + ` RETURNING 1 ),
+		session AS ( UPDATE attendance_sessions SET logout=$10, logout_at=$11 WHERE student_id=$8 AND login_at=$9 AND logout_at IS NULL AND EXISTS ( SELECT 1 FROM punch ) )
+		INSERT INTO outbox_events (event_type,payload) SELECT $12,$13 WHERE EXISTS ( SELECT 1 FROM punch )`,
+
+	// a write behind batch as arrays of its rows, the records whose punch was new are returned
+	stmtWriteAttendance: `WITH batch AS (
+			SELECT * FROM unnest($1::INT[],$2::TEXT[],$3::TEXT[],$4::TEXT[],$5::BIGINT[],$6::TIMESTAMPTZ[],$7::TIMESTAMPTZ[],$8::DATE[],$9::TEXT[],$10::DATE[],$11::TIMESTAMPTZ[],$12::TIME[],$13::TIMESTAMPTZ[],$14::JSONB[])
+				AS b (record,unit_id,student_unit_id,student_id,punch_index,scanned_at,received_at,business_date,event_type,session_date,login_at,clock,logout_at,payload)
+		),
+		punch AS ( INSERT INTO attendance_punches (unit_id,student_unit_id,student_id,punch_index,scanned_at,received_at,business_date)
+			SELECT unit_id,student_unit_id,student_id,punch_index,scanned_at,received_at,business_date FROM batch
+			ON CONFLICT (unit_id,punch_index,scanned_at) DO NOTHING RETURNING unit_id,punch_index,scanned_at ),
+		recorded AS ( SELECT batch.* FROM batch JOIN punch USING (unit_id,punch_index,scanned_at) ),
+		login AS ( INSERT INTO attendance_sessions (student_id,date,login,login_at)
+			SELECT student_id,session_date,clock,login_at FROM recorded WHERE event_type='` + models.EventAttendanceLogin + `' ),
+		logout AS ( UPDATE attendance_sessions s SET logout=r.clock, logout_at=r.logout_at FROM recorded r
+			WHERE r.event_type='` + models.EventAttendanceLogout + `' AND s.student_id=r.student_id AND s.login_at=r.login_at AND s.logout_at IS NULL ),
+		event AS ( INSERT INTO outbox_events (event_type,payload) SELECT event_type,payload FROM recorded WHERE event_type IS NOT NULL )
+		SELECT record FROM recorded`,
+
+	stmtDeleteStudentDeletes: `WITH removed AS ( DELETE FROM deletes WHERE unit_id=$1 AND student_unit_id=$2 RETURNING 1 )
+		INSERT INTO outbox_events (event_type,payload) SELECT $3,$4 WHERE EXISTS ( SELECT 1 FROM removed )`,
+
+	stmtDeleteStudentInserts: `WITH removed AS ( DELETE FROM inserts WHERE unit_id=$1 AND student_unit_id=$2 RETURNING 1 )
+		INSERT INTO outbox_events (event_type,payload) SELECT $3,$4 WHERE EXISTS ( SELECT 1 FROM removed )`,
+}
+
+// prepared runs fn on a pooled connection that has the named statements prepared.
+// Prepare returns without a round trip for statements the connection already knows.
+func (repo *postgresRepository) prepared(ctx context.Context, fn func(conn *pgx.Conn) error, names ...string) error {
+	pooledConn, err := repo.dbConn.Acquire(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer pooledConn.Release()
+
+	conn := pooledConn.Conn()
+
+	for _, name := range names {
+		if _, err := conn.Prepare(ctx, name, statements[name]); err != nil {
+			return err
+		}
+	}
+
+	return fn(conn)
+}
+
+// RoundTripTracer counts the round trips to the database, a batch is sent as one
+// and a statement that is already prepared costs none to prepare.
+type RoundTripTracer struct{}
+
+func (RoundTripTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	metrics.DatabaseRoundTrips.Add(1)
+	return ctx
+}
+
+func (RoundTripTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func (RoundTripTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceBatchStartData) context.Context {
+	metrics.DatabaseRoundTrips.Add(1)
+	return ctx
+}
+
+func (RoundTripTracer) TraceBatchQuery(context.Context, *pgx.Conn, pgx.TraceBatchQueryData) {}
+
+func (RoundTripTracer) TraceBatchEnd(context.Context, *pgx.Conn, pgx.TraceBatchEndData) {}
+
+func (RoundTripTracer) TracePrepareStart(ctx context.Context, _ *pgx.Conn, _ pgx.TracePrepareStartData) context.Context {
+	return ctx
+}
+
+func (RoundTripTracer) TracePrepareEnd(_ context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	if !data.AlreadyPrepared {
+		metrics.DatabaseRoundTrips.Add(1)
+	}
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// The benchmarks need a postgres database, set BENCH_DATABASE_URL to run them. They
+// work in a schema of their own that is dropped afterwards, created with the tables
+// the migrations expect to exist:
+//
+//	BENCH_DATABASE_URL=postgres://localhost/postgres go test ./repository -run '^$' -bench RoundTrips
+
+const benchDevice = "bench-device"
+
+var benchTables = []string{
+	`CREATE TABLE biometric ( unit_id TEXT PRIMARY KEY, online BOOLEAN NOT NULL DEFAULT false )`,
+	`CREATE TABLE fingerprintdata ( unit_id TEXT NOT NULL, student_unit_id TEXT NOT NULL, student_id TEXT NOT NULL, fingerprint_data TEXT NOT NULL DEFAULT '' )`,
+	`CREATE TABLE inserts ( unit_id TEXT NOT NULL, student_unit_id TEXT NOT NULL, fingerprint_data TEXT NOT NULL )`,
+	`CREATE TABLE deletes ( unit_id TEXT NOT NULL, student_unit_id TEXT NOT NULL )`,
+	`CREATE TABLE attendance ( student_id TEXT NOT NULL, date TEXT NOT NULL, login TEXT NOT NULL, logout TEXT NOT NULL )`,
+}
+
+func benchDatabase(b *testing.B) *pgxpool.Pool {
+	b.Helper()
+
+	url := os.Getenv("BENCH_DATABASE_URL")
+
+	if url == "" {
+		b.Skip("BENCH_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+
+	schema := fmt.Sprintf("bench_%v", time.Now().UnixNano())
+
+	admin, err := pgx.Connect(ctx, url)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(func() {
+		admin.Exec(ctx, `DROP SCHEMA `+schema+` CASCADE`)
+		admin.Close(ctx)
+	})
+
+	if _, err := admin.Exec(ctx, `CREATE SCHEMA `+schema); err != nil {
+		b.Fatal(err)
+	}
+
+	config, err := pgxpool.ParseConfig(url)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	config.MaxConns = 1
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	config.ConnConfig.Tracer = RoundTripTracer{}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(pool.Close)
+
+	for _, table := range benchTables {
+		if _, err := pool.Exec(ctx, table); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	if err := Migrate(pool); err != nil {
+		b.Fatal(err)
+	}
+
+	if _, err := pool.Exec(ctx, `INSERT INTO biometric (unit_id) VALUES ($1)`, benchDevice); err != nil {
+		b.Fatal(err)
+	}
+
+	if _, err := pool.Exec(ctx, `INSERT INTO fingerprintdata (unit_id,student_unit_id,student_id) VALUES ($1,'7','student-7')`, benchDevice); err != nil {
+		b.Fatal(err)
+	}
+
+	return pool
+}
+
+// attendancePath is the database work of one attendance message that opens a session.
+type attendancePath func(punch *models.Punch) error
+
+// sequentialAttendance issues the queries one after another and writes the punch,
+// session and event in a transaction, as the repository did before the named
+// statements, batches and single statement writes.
+func sequentialAttendance(pool *pgxpool.Pool) attendancePath {
+	ctx := context.Background()
+
+	return func(punch *models.Punch) error {
+		var studentId string
+
+		if err := pool.QueryRow(ctx, `SELECT student_id FROM fingerprintdata WHERE unit_id=$1 AND student_unit_id=$2`, punch.UnitId, punch.StudentUnitId).Scan(&studentId); err != nil {
+			return err
+		}
+
+		var timezone, institutionTimezone string
+		var debounceSeconds *int32
+		var dayStartSeconds int64
+
+		if err := pool.QueryRow(ctx, statements[stmtGetDeviceSettings], punch.UnitId).Scan(&timezone, &institutionTimezone, &debounceSeconds, &dayStartSeconds); err != nil {
+			return err
+		}
+
+		rows, err := pool.Query(ctx, statements[stmtGetDeviceShifts], punch.UnitId)
+
+		if err != nil {
+			return err
+		}
+
+		rows.Close()
+
+		rows, err = pool.Query(ctx, statements[stmtGetOpenAttendance], studentId, punch.ScannedAt.Add(-16*time.Hour))
+
+		if err != nil {
+			return err
+		}
+
+		rows.Close()
+
+		tx, err := pool.Begin(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		defer tx.Rollback(ctx)
+
+		if _, err := tx.Exec(ctx, punchInsert, punchArguments(punch)...); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO attendance_sessions (student_id,date,login,login_at) VALUES ($1,$2,$3,$4)`, studentId, punch.BusinessDate, wallClock(punch.ScannedAt), punch.ScannedAt); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO outbox_events (event_type,payload) VALUES ($1,$2)`, models.EventAttendanceLogin, `{}`); err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	}
+}
+
+func repositoryAttendance(pool *pgxpool.Pool) attendancePath {
+	repo := NewPostgresRepository(pool, nil)
+
+	return func(punch *models.Punch) error {
+		studentId, err := repo.GetStudentId(punch.UnitId, punch.StudentUnitId)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := repo.GetDeviceSettings(punch.UnitId); err != nil {
+			return err
+		}
+
+		if _, _, err := repo.GetOpenAttendance(studentId, punch.ScannedAt.Add(-16*time.Hour)); err != nil {
+			return err
+		}
+
+		_, err = repo.InsertAttendanceLog(&models.Attendance{StudentId: studentId, Date: punch.BusinessDate, LoginAt: punch.ScannedAt}, punch)
+		return err
+	}
+}
+
+// BenchmarkAttendanceRoundTrips reports the round trips of one attendance message,
+// counted by RoundTripTracer, before and after the repository pipelined its queries.
+func BenchmarkAttendanceRoundTrips(b *testing.B) {
+	paths := []struct {
+		name string
+		path func(*pgxpool.Pool) attendancePath
+	}{
+		{"sequential", sequentialAttendance},
+		{"repository", repositoryAttendance},
+	}
+
+	for _, path := range paths {
+		b.Run(path.name, func(b *testing.B) {
+			pool := benchDatabase(b)
+			record := path.path(pool)
+
+			scannedAt := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+
+			//the first message prepares the statements of the connection
+			if err := record(benchPunch(0, scannedAt)); err != nil {
+				b.Fatal(err)
+			}
+
+			before := metrics.DatabaseRoundTrips.Value()
+
+			b.ResetTimer()
+
+			for i := 1; i <= b.N; i++ {
+				if err := record(benchPunch(i, scannedAt)); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+
+			b.ReportMetric(float64(metrics.DatabaseRoundTrips.Value()-before)/float64(b.N), "round_trips/op")
+		})
+	}
+}
+
+func benchPunch(index int, scannedAt time.Time) *models.Punch {
+	scannedAt = scannedAt.Add(time.Duration(index) * time.Second)
+
+	return &models.Punch{
+		UnitId:        benchDevice,
+		StudentUnitId: "7",
+		StudentId:     "student-7",
+		Index:         uint32(index),
+		ScannedAt:     scannedAt,
+		ReceivedAt:    scannedAt,
+		BusinessDate:  time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+	}
+}
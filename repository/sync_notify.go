@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+func (repo *postgresRepository) GetPendingSyncCounts(deviceId string) (*models.PendingSync, error) {
+	query := `SELECT ( SELECT COUNT(*) FROM inserts WHERE unit_id=$1 AND quarantined_at IS NULL ), ( SELECT COUNT(*) FROM deletes WHERE unit_id=$1 )`
+	pending := &models.PendingSync{UnitId: deviceId}
+	err := repo.dbConn.QueryRow(context.Background(), query, deviceId).Scan(&pending.Inserts, &pending.Deletes)
+	return pending, err
+}
+
+func (repo *postgresRepository) ListOnlinePendingSync() ([]models.PendingSync, error) {
+	query := `SELECT unit_id, pending_inserts, pending_deletes FROM (
+			SELECT b.unit_id,
+				( SELECT COUNT(*) FROM inserts i WHERE i.unit_id = b.unit_id AND i.quarantined_at IS NULL ) AS pending_inserts,
+				( SELECT COUNT(*) FROM deletes d WHERE d.unit_id = b.unit_id ) AS pending_deletes
+			FROM biometric b WHERE b.online
+		) p WHERE pending_inserts > 0 OR pending_deletes > 0`
+
+	rows, err := repo.dbConn.Query(context.Background(), query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var devices []models.PendingSync
+
+	for rows.Next() {
+		var pending models.PendingSync
+
+		if err := rows.Scan(&pending.UnitId, &pending.Inserts, &pending.Deletes); err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, pending)
+	}
+
+	return devices, rows.Err()
+}
+
+// ListenSyncQueue blocks on a dedicated pool connection and calls handler with the
+// unit id of every row queued in inserts or deletes. It returns when the connection fails.
+func (repo *postgresRepository) ListenSyncQueue(handler func(deviceId string)) error {
+	return repo.listen([]string{"sync_queue"}, func(notification *pgconn.Notification) {
+		handler(notification.Payload)
+	})
+}
+
+// ListenRegistry reports changes of registered devices and of the student unit id
+// mapping, onReset is called when a table was truncated.
+func (repo *postgresRepository) ListenRegistry(onDevice func(unitId string), onStudent func(unitId string, studentUnitId string), onReset func()) error {
+	return repo.listen([]string{"device_registry", "student_mapping"}, func(notification *pgconn.Notification) {
+		if notification.Payload == "" {
+			onReset()
+			return
+		}
+
+		if notification.Channel == "device_registry" {
+			onDevice(notification.Payload)
+			return
+		}
+
+		unitId, studentUnitId, ok := strings.Cut(notification.Payload, "/")
+
+		if !ok {
+			onReset()
+			return
+		}
+
+		onStudent(unitId, studentUnitId)
+	})
+}
+
+// listen blocks on a dedicated pool connection until it fails.
+func (repo *postgresRepository) listen(channels []string, handler func(notification *pgconn.Notification)) error {
+	ctx := context.Background()
+
+	pooledConn, err := repo.dbConn.Acquire(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	//the session level LISTEN must not leak back into the pool
+	conn := pooledConn.Hijack()
+
+	defer conn.Close(ctx)
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, `LISTEN `+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		handler(notification)
+	}
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"log"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type writeResult struct {
+	recorded bool
+	err      error
+}
+
+type pendingWrite struct {
+	write  models.AttendanceWrite
+	result chan writeResult
+}
+
+// writeBehindRepository buffers the attendance writes for up to an interval and stores
+// them as one batch, every other call goes to the wrapped repository. A write returns
+// once its batch committed, so devices are only acknowledged for stored punches.
+type writeBehindRepository struct {
+	models.DeviceDatabseInterface
+	batchRepo models.AttendanceBatchDatabaseInterface
+	interval  time.Duration
+	maxBatch  int
+	queue     chan *pendingWrite
+}
+
+func NewWriteBehindRepository(repo models.DeviceDatabseInterface, batchRepo models.AttendanceBatchDatabaseInterface, interval time.Duration, maxBatch int) *writeBehindRepository {
+	r := &writeBehindRepository{
+		DeviceDatabseInterface: repo,
+		batchRepo:              batchRepo,
+		interval:               interval,
+		maxBatch:               maxBatch,
+		queue:                  make(chan *pendingWrite, maxBatch),
+	}
+
+	metrics.PublishQueue("attendance_write_queue", func() metrics.QueueStats {
+		return metrics.QueueStats{
+			Length:   len(r.queue),
+			Capacity: cap(r.queue),
+		}
+	})
+
+	go r.run()
+
+	return r
+}
+
+func (r *writeBehindRepository) InsertPunch(punch *models.Punch) (bool, error) {
+	return r.write(models.AttendanceWrite{Punch: punch})
+}
+
+func (r *writeBehindRepository) InsertAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	return r.write(models.AttendanceWrite{Punch: punch, Attendance: attendanceLog, Event: models.EventAttendanceLogin})
+}
+
+func (r *writeBehindRepository) UpdateAttendanceLog(attendanceLog *models.Attendance, punch *models.Punch) (bool, error) {
+	return r.write(models.AttendanceWrite{Punch: punch, Attendance: attendanceLog, Event: models.EventAttendanceLogout})
+}
+
+func (r *writeBehindRepository) write(write models.AttendanceWrite) (bool, error) {
+	pending := &pendingWrite{
+		write:  write,
+		result: make(chan writeResult, 1),
+	}
+
+	r.queue <- pending
+
+	result := <-pending.result
+
+	return result.recorded, result.err
+}
+
+// run collects a batch from its first write until the interval passed or the batch
+// is full, writes arriving during a flush wait for the next batch.
+func (r *writeBehindRepository) run() {
+	for first := range r.queue {
+		batch := []*pendingWrite{first}
+
+		timer := time.NewTimer(r.interval)
+
+	collect:
+		for len(batch) < r.maxBatch {
+			select {
+			case pending := <-r.queue:
+				batch = append(batch, pending)
+			case <-timer.C:
+				break collect
+			}
+		}
+
+		timer.Stop()
+
+		r.flush(batch)
+	}
+}
+
+// flush fails the whole batch when the database is unavailable. Any other error is
+// likely caused by a single write, the batch is then retried one write at a time so
+// that only the offending write fails.
+func (r *writeBehindRepository) flush(batch []*pendingWrite) {
+	writes := make([]models.AttendanceWrite, len(batch))
+
+	for i, pending := range batch {
+		writes[i] = pending.write
+	}
+
+	metrics.AttendanceBatches.Add(1)
+	metrics.AttendanceBatchedWrites.Add(int64(len(batch)))
+
+	recorded, err := r.batchRepo.WriteAttendance(writes)
+
+	if err == nil {
+		for i, pending := range batch {
+			pending.result <- writeResult{recorded: recorded[i]}
+		}
+		return
+	}
+
+	if isUnavailable(err) {
+		for _, pending := range batch {
+			pending.result <- writeResult{err: err}
+		}
+		return
+	}
+
+	log.Println("failed to write the attendance batch, writing one at a time, Error: ", err.Error())
+
+	metrics.AttendanceBatchFallbacks.Add(1)
+
+	for _, pending := range batch {
+		recorded, err := r.writeOne(pending.write)
+		pending.result <- writeResult{recorded: recorded, err: err}
+	}
+}
+
+func (r *writeBehindRepository) writeOne(write models.AttendanceWrite) (bool, error) {
+	switch write.Event {
+	case models.EventAttendanceLogin:
+		return r.DeviceDatabseInterface.InsertAttendanceLog(write.Attendance, write.Punch)
+	case models.EventAttendanceLogout:
+		return r.DeviceDatabseInterface.UpdateAttendanceLog(write.Attendance, write.Punch)
+	default:
+		return r.DeviceDatabseInterface.InsertPunch(write.Punch)
+	}
+}
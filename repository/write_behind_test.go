@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// batchRecorder passes batches to the memory repository and keeps their sizes, or
+// fails every batch with err.
+type batchRecorder struct {
+	repo  *memoryRepository
+	err   error
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (b *batchRecorder) WriteAttendance(writes []models.AttendanceWrite) ([]bool, error) {
+	b.mu.Lock()
+	b.sizes = append(b.sizes, len(writes))
+	b.mu.Unlock()
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return b.repo.WriteAttendance(writes)
+}
+
+func (b *batchRecorder) batchSizes() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]int(nil), b.sizes...)
+}
+
+// singleWrites counts the writes that bypass the batch and fails the punch index failIndex.
+type singleWrites struct {
+	models.DeviceDatabseInterface
+	failIndex uint32
+	mu        sync.Mutex
+	calls     int
+}
+
+func (s *singleWrites) InsertPunch(punch *models.Punch) (bool, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+
+	if punch.Index == s.failIndex {
+		return false, errors.New("punch rejected")
+	}
+
+	return s.DeviceDatabseInterface.InsertPunch(punch)
+}
+
+func newMemoryForTest(t *testing.T) *memoryRepository {
+	t.Helper()
+
+	repo, err := NewMemoryRepository(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo
+}
+
+func testPunch(index uint32) *models.Punch {
+	scannedAt := time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)
+
+	return &models.Punch{
+		UnitId:        "vs23cg003",
+		StudentUnitId: "7",
+		StudentId:     "student-7",
+		Index:         index,
+		ScannedAt:     scannedAt,
+		ReceivedAt:    scannedAt,
+		BusinessDate:  time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+type writeOutcome struct {
+	recorded bool
+	err      error
+}
+
+// writeConcurrently runs every write on its own goroutine, as the workers of the
+// processor do, and returns the outcomes in the order of writes.
+func writeConcurrently(t *testing.T, writes []func() (bool, error)) []writeOutcome {
+	t.Helper()
+
+	outcomes := make([]writeOutcome, len(writes))
+
+	var wg sync.WaitGroup
+
+	for i, write := range writes {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			recorded, err := write()
+			outcomes[i] = writeOutcome{recorded: recorded, err: err}
+		}()
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes did not return")
+	}
+
+	return outcomes
+}
+
+func TestWriteBehindFlushesFullBatch(t *testing.T) {
+	memory := newMemoryForTest(t)
+	batches := &batchRecorder{repo: memory}
+
+	//the interval never passes, only a full batch is flushed
+	repo := NewWriteBehindRepository(memory, batches, time.Hour, 4)
+
+	var writes []func() (bool, error)
+
+	for i := uint32(1); i <= 4; i++ {
+		writes = append(writes, func() (bool, error) { return repo.InsertPunch(testPunch(i)) })
+	}
+
+	for i, outcome := range writeConcurrently(t, writes) {
+		if outcome.err != nil || !outcome.recorded {
+			t.Errorf("write %v: got %+v, want a recorded punch", i, outcome)
+		}
+	}
+
+	if sizes := batches.batchSizes(); len(sizes) != 1 || sizes[0] != 4 {
+		t.Errorf("got batches %v, want one batch of 4", sizes)
+	}
+}
+
+func TestWriteBehindFlushesAfterInterval(t *testing.T) {
+	memory := newMemoryForTest(t)
+	batches := &batchRecorder{repo: memory}
+
+	interval := 20 * time.Millisecond
+
+	repo := NewWriteBehindRepository(memory, batches, interval, 100)
+
+	start := time.Now()
+
+	outcomes := writeConcurrently(t, []func() (bool, error){
+		func() (bool, error) { return repo.InsertPunch(testPunch(1)) },
+	})
+
+	if elapsed := time.Since(start); elapsed < interval {
+		t.Errorf("write returned after %v, before the flush interval of %v", elapsed, interval)
+	}
+
+	if !outcomes[0].recorded || outcomes[0].err != nil {
+		t.Errorf("got %+v, want a recorded punch", outcomes[0])
+	}
+
+	if sizes := batches.batchSizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("got batches %v, want one batch of 1", sizes)
+	}
+}
+
+func TestWriteBehindMapsResultsToWrites(t *testing.T) {
+	memory := newMemoryForTest(t)
+	batches := &batchRecorder{repo: memory}
+
+	//punch 1 was delivered before, the batch sees it again
+	if _, err := memory.InsertPunch(testPunch(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewWriteBehindRepository(memory, batches, time.Hour, 4)
+
+	login := testPunch(2)
+	login.StudentId = "student-8"
+
+	attendanceLog := &models.Attendance{StudentId: "student-8", Date: login.BusinessDate, LoginAt: login.ScannedAt}
+
+	outcomes := writeConcurrently(t, []func() (bool, error){
+		func() (bool, error) { return repo.InsertPunch(testPunch(1)) },
+		func() (bool, error) { return repo.InsertAttendanceLog(attendanceLog, login) },
+		func() (bool, error) { return repo.InsertPunch(testPunch(3)) },
+		func() (bool, error) { return repo.InsertPunch(testPunch(3)) },
+	})
+
+	for i, outcome := range outcomes {
+		if outcome.err != nil {
+			t.Fatalf("write %v failed, Error: %v", i, outcome.err)
+		}
+	}
+
+	if outcomes[0].recorded {
+		t.Error("a punch stored before the batch was reported as new")
+	}
+
+	if !outcomes[1].recorded {
+		t.Error("a new login punch was reported as stored before")
+	}
+
+	//the same punch twice in one batch is only new once, either of them may come first
+	if outcomes[2].recorded == outcomes[3].recorded {
+		t.Errorf("repeated punch in one batch: got %v and %v, want exactly one new", outcomes[2].recorded, outcomes[3].recorded)
+	}
+
+	if _, open, err := memory.GetOpenAttendance("student-8", login.ScannedAt.Add(-time.Hour)); err != nil || !open {
+		t.Errorf("the login of the batch did not open a session, open: %v Error: %v", open, err)
+	}
+}
+
+func TestWriteBehindFallsBackToSingleWrites(t *testing.T) {
+	memory := newMemoryForTest(t)
+	batches := &batchRecorder{repo: memory, err: errors.New("duplicate key value violates unique constraint")}
+	single := &singleWrites{DeviceDatabseInterface: memory, failIndex: 2}
+
+	repo := NewWriteBehindRepository(single, batches, time.Hour, 3)
+
+	var writes []func() (bool, error)
+
+	for i := uint32(1); i <= 3; i++ {
+		writes = append(writes, func() (bool, error) { return repo.InsertPunch(testPunch(i)) })
+	}
+
+	outcomes := writeConcurrently(t, writes)
+
+	for i, outcome := range outcomes {
+		index := i + 1
+
+		switch {
+		case index == 2 && outcome.err == nil:
+			t.Errorf("write of punch %v succeeded, want only its own error", index)
+		case index != 2 && (outcome.err != nil || !outcome.recorded):
+			t.Errorf("write of punch %v: got %+v, want a recorded punch", index, outcome)
+		}
+	}
+
+	if single.calls != 3 {
+		t.Errorf("got %v single writes, want 3", single.calls)
+	}
+}
+
+func TestWriteBehindFailsBatchWhileUnavailable(t *testing.T) {
+	memory := newMemoryForTest(t)
+	batches := &batchRecorder{repo: memory, err: io.ErrUnexpectedEOF}
+	single := &singleWrites{DeviceDatabseInterface: memory}
+
+	repo := NewWriteBehindRepository(single, batches, time.Hour, 2)
+
+	outcomes := writeConcurrently(t, []func() (bool, error){
+		func() (bool, error) { return repo.InsertPunch(testPunch(1)) },
+		func() (bool, error) { return repo.InsertPunch(testPunch(2)) },
+	})
+
+	for i, outcome := range outcomes {
+		if !errors.Is(outcome.err, io.ErrUnexpectedEOF) {
+			t.Errorf("write %v: got %+v, want the batch error", i, outcome)
+		}
+	}
+
+	if single.calls != 0 {
+		t.Errorf("got %v single writes while the database is unavailable, want none", single.calls)
+	}
+}
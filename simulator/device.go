@@ -0,0 +1,198 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/vithsutra/biometric-project-message-processor/codec"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+	"github.com/vithsutra/biometric-project-message-processor/topic"
+)
+
+const responseBufferSize = 64
+
+var ErrResponseTimeout = errors.New("timed out waiting for the response")
+
+type Options struct {
+	Broker          string
+	UserName        string
+	Password        string
+	RequestTopic    *topic.Template
+	ResponseTopic   *topic.Template
+	Tenant          string
+	Site            string
+	Codec           codec.Codec
+	ProtocolVersion uint8
+	Location        *time.Location
+	ResponseTimeout time.Duration
+}
+
+type response struct {
+	messageType uint8
+	payload     []byte
+}
+
+type messageHeader struct {
+	MessageType uint8 `json:"mty"`
+}
+
+// Device is one virtual device with its own mqtt connection, publishing requests
+// the way the firmware does and collecting the responses sent to its topic.
+type Device struct {
+	Id        string
+	options   *Options
+	client    mqtt.Client
+	responses chan response
+	index     uint32
+}
+
+func NewDevice(id string, options *Options) *Device {
+	return &Device{
+		Id:        id,
+		options:   options,
+		responses: make(chan response, responseBufferSize),
+		//start from the clock so that indexes of repeated runs do not collide
+		index: uint32(time.Now().Unix()),
+	}
+}
+
+func (d *Device) route(messageType string) topic.Route {
+	route := topic.Route{
+		Tenant:   d.options.Tenant,
+		Site:     d.options.Site,
+		DeviceId: d.Id,
+		Type:     messageType,
+	}
+
+	if d.options.ProtocolVersion != 0 {
+		route.Version = models.FormatProtocolVersion(d.options.ProtocolVersion)
+	} else {
+		route.Version = models.FormatProtocolVersion(models.DefaultProtocolVersion)
+	}
+
+	return route
+}
+
+func (d *Device) Connect() error {
+	opts := mqtt.NewClientOptions()
+
+	opts.AddBroker(d.options.Broker)
+	opts.SetClientID("simulator-" + d.Id)
+	opts.SetUsername(d.options.UserName)
+	opts.SetPassword(d.options.Password)
+	opts.SetCleanSession(true)
+
+	d.client = mqtt.NewClient(opts)
+
+	if token := d.client.Connect(); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	responseTopic, err := d.options.ResponseTopic.Format(d.route("+"))
+
+	if err != nil {
+		return err
+	}
+
+	token := d.client.Subscribe(responseTopic, 1, func(c mqtt.Client, m mqtt.Message) {
+		header := new(messageHeader)
+
+		if err := codec.Detect(m.Payload()).Unmarshal(m.Payload(), header); err != nil {
+			header.MessageType = 0
+		}
+
+		select {
+		case d.responses <- response{messageType: header.MessageType, payload: m.Payload()}:
+		default:
+		}
+	})
+
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+func (d *Device) Close() {
+	if d.client != nil {
+		d.client.Disconnect(250)
+	}
+}
+
+// Send publishes a request without waiting for a response. A nil request sends an
+// empty payload, like the firmware does for connection and disconnection.
+func (d *Device) Send(messageType string, request any) error {
+	requestTopic, err := d.options.RequestTopic.Format(d.route(messageType))
+
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+
+	if request != nil {
+		if payload, err = d.options.Codec.Marshal(request); err != nil {
+			return err
+		}
+	}
+
+	token := d.client.Publish(requestTopic, 1, false, payload)
+
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+// Request sends a request and waits for the response with the expected message
+// type, the response is decoded into v. Responses left over from earlier requests
+// are dropped first, unsolicited sync available commands are skipped.
+func (d *Device) Request(messageType string, request any, expect uint8, v any) (time.Duration, error) {
+	for drained := false; !drained; {
+		select {
+		case <-d.responses:
+		default:
+			drained = true
+		}
+	}
+
+	sentAt := time.Now()
+
+	if err := d.Send(messageType, request); err != nil {
+		return 0, err
+	}
+
+	err := d.Receive(expect, v)
+
+	return time.Since(sentAt), err
+}
+
+func (d *Device) Receive(expect uint8, v any) error {
+	timeout := time.NewTimer(d.options.ResponseTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case r := <-d.responses:
+			if r.messageType == 7 && expect != 7 {
+				continue
+			}
+
+			if r.messageType != expect {
+				return fmt.Errorf("expected message type %v, received %v", expect, r.messageType)
+			}
+
+			if err := codec.Detect(r.payload).Unmarshal(r.payload, v); err != nil {
+				return fmt.Errorf("invalid response payload: %w", err)
+			}
+
+			return nil
+		case <-timeout.C:
+			return ErrResponseTimeout
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const (
+	schedulerTick = 10 * time.Millisecond
+	sampleEvery   = time.Second
+)
+
+// LoadTypes are the requests a load test can generate, they only read the sync
+// queues so that the database state does not drift during a run.
+var LoadTypes = []string{"connection", "deletesync", "insertsync", "attendance"}
+
+// LoadProfile ramps every message type linearly from zero to its rate in requests
+// per second over Ramp, and holds the rates for Duration.
+type LoadProfile struct {
+	Rates    map[string]float64
+	Ramp     time.Duration
+	Duration time.Duration
+	Students []uint16
+}
+
+// MetricsSample is one reading of the processor metrics, the counters only make
+// sense as the difference between two samples.
+type MetricsSample struct {
+	Queue      metrics.QueueStats
+	QueueFull  int64
+	Messages   int64
+	RoundTrips int64
+}
+
+// MetricsSampler reads the queue and the database round trips of the processor.
+type MetricsSampler func() (*MetricsSample, error)
+
+type metricsResponse struct {
+	MessageQueue       metrics.QueueStats `json:"message_queue"`
+	MessageQueueFull   int64              `json:"message_queue_full"`
+	MessagesProcessed  map[string]int64   `json:"messages_processed"`
+	DatabaseRoundTrips int64              `json:"database_round_trips"`
+}
+
+// NewMetricsSampler samples the metrics endpoint of the admin api.
+func NewMetricsSampler(url string, token string) MetricsSampler {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+
+	return func() (*MetricsSample, error) {
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		response, err := httpClient.Do(request)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code %v", response.StatusCode)
+		}
+
+		body := new(metricsResponse)
+
+		if err := json.NewDecoder(response.Body).Decode(body); err != nil {
+			return nil, err
+		}
+
+		sample := &MetricsSample{
+			Queue:      body.MessageQueue,
+			QueueFull:  body.MessageQueueFull,
+			RoundTrips: body.DatabaseRoundTrips,
+		}
+
+		for _, count := range body.MessagesProcessed {
+			sample.Messages += count
+		}
+
+		return sample, nil
+	}
+}
+
+// Load connects the devices, then generates requests following the profile. Every
+// device has at most one request in flight, a request due while all devices are
+// busy is skipped and counted, which shows that the fleet is too small for the rate.
+func Load(options *Options, deviceIds []string, profile *LoadProfile, sampler MetricsSampler) *Report {
+	report := NewReport()
+	report.Devices = len(deviceIds)
+
+	var devices []*Device
+
+	for _, deviceId := range deviceIds {
+		device := NewDevice(deviceId, options)
+
+		if err := device.Connect(); err != nil {
+			report.Record(deviceId, "mqtt", err)
+			continue
+		}
+
+		defer device.Close()
+
+		devices = append(devices, device)
+	}
+
+	jobs := make(chan string, len(devices))
+
+	var workers sync.WaitGroup
+
+	for _, device := range devices {
+		workers.Add(1)
+
+		go func(device *Device) {
+			defer workers.Done()
+
+			for messageType := range jobs {
+				latency, err := device.call(messageType, profile.Students)
+				report.RecordLatency(device.Id, messageType, latency, err)
+			}
+		}(device)
+	}
+
+	startedAt := time.Now()
+	total := profile.Ramp + profile.Duration
+
+	done := make(chan struct{})
+	var background sync.WaitGroup
+
+	if sampler != nil {
+		background.Add(1)
+
+		go func() {
+			defer background.Done()
+			report.Queue, report.Database = sampleMetrics(sampler, done)
+		}()
+	}
+
+	ticker := time.NewTicker(schedulerTick)
+
+	credits := make(map[string]float64, len(profile.Rates))
+	last := startedAt
+
+	for now := range ticker.C {
+		elapsed := now.Sub(startedAt)
+
+		if elapsed >= total {
+			break
+		}
+
+		ramp := 1.0
+
+		if profile.Ramp > 0 && elapsed < profile.Ramp {
+			ramp = float64(elapsed) / float64(profile.Ramp)
+		}
+
+		step := now.Sub(last).Seconds()
+		last = now
+
+		for messageType, rate := range profile.Rates {
+			credits[messageType] += rate * ramp * step
+
+			for credits[messageType] >= 1 {
+				credits[messageType]--
+
+				select {
+				case jobs <- messageType:
+				default:
+					report.Skip()
+				}
+			}
+		}
+	}
+
+	ticker.Stop()
+	close(jobs)
+	workers.Wait()
+
+	close(done)
+	background.Wait()
+
+	report.Duration = time.Since(startedAt).Seconds()
+
+	return report
+}
+
+func sampleMetrics(sampler MetricsSampler, done chan struct{}) (*QueueReport, *DatabaseReport) {
+	queue := new(QueueReport)
+	database := new(DatabaseReport)
+
+	ticker := time.NewTicker(sampleEvery)
+	defer ticker.Stop()
+
+	var first *MetricsSample
+
+	for {
+		if sample, err := sampler(); err == nil {
+			if first == nil {
+				first = sample
+			}
+
+			queue.Samples++
+			queue.Capacity = sample.Queue.Capacity
+			queue.MaxLength = max(queue.MaxLength, sample.Queue.Length)
+			queue.FullEvents = sample.QueueFull - first.QueueFull
+
+			if sample.Queue.Capacity > 0 {
+				queue.MaxSaturation = max(queue.MaxSaturation, float64(sample.Queue.Length)/float64(sample.Queue.Capacity))
+			}
+
+			database.Messages = sample.Messages - first.Messages
+			database.RoundTrips = sample.RoundTrips - first.RoundTrips
+
+			if database.Messages > 0 {
+				database.RoundTripsPerMessage = float64(database.RoundTrips) / float64(database.Messages)
+			}
+		}
+
+		select {
+		case <-done:
+			return queue, database
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Device) call(messageType string, students []uint16) (time.Duration, error) {
+	switch messageType {
+	case "connection":
+		return d.connection()
+	case "deletesync":
+		response := new(models.DeleteSyncResponse)
+
+		latency, err := d.Request("deletesync", nil, 2, response)
+
+		if err == nil && response.ErrorStatus != 0 {
+			err = fmt.Errorf("delete sync failed with est %v ecd %v", response.ErrorStatus, response.ErrorCode)
+		}
+
+		return latency, err
+	case "insertsync":
+		response := new(models.InsertSyncResponse)
+
+		latency, err := d.Request("insertsync", nil, 4, response)
+
+		if err == nil && response.ErrorStatus != 0 {
+			err = fmt.Errorf("insert sync failed with est %v ecd %v", response.ErrorStatus, response.ErrorCode)
+		}
+
+		return latency, err
+	case "attendance":
+		if len(students) == 0 {
+			return 0, fmt.Errorf("attendance load needs student unit ids")
+		}
+
+		return d.Attendance(students[rand.Intn(len(students))])
+	default:
+		return 0, fmt.Errorf("unsupported load message type %q", messageType)
+	}
+}
@@ -0,0 +1,235 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxReportedErrors = 20
+	maxLatencySamples = 1000000
+)
+
+type TypeStats struct {
+	Sent      int            `json:"sent"`
+	Ok        int            `json:"ok"`
+	Failed    int            `json:"failed"`
+	Timeouts  int            `json:"timeouts"`
+	ErrorRate float64        `json:"error_rate"`
+	Latency   *LatencyReport `json:"latency_ms,omitempty"`
+	latencies []time.Duration
+}
+
+type LatencyReport struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// QueueReport is the saturation of the processor queue sampled from the admin
+// api metrics during a load test.
+type QueueReport struct {
+	Capacity      int     `json:"capacity"`
+	MaxLength     int     `json:"max_length"`
+	MaxSaturation float64 `json:"max_saturation"`
+	FullEvents    int64   `json:"full_events"`
+	Samples       int     `json:"samples"`
+}
+
+// DatabaseReport counts the database round trips the processor made for the
+// messages of a load test, run one message type at a time to compare handlers.
+type DatabaseReport struct {
+	Messages             int64   `json:"messages"`
+	RoundTrips           int64   `json:"round_trips"`
+	RoundTripsPerMessage float64 `json:"round_trips_per_message"`
+}
+
+// Report collects the outcome of every request of a run, it is safe for
+// concurrent use by all devices.
+type Report struct {
+	mu         sync.Mutex
+	Devices    int                   `json:"devices"`
+	Duration   float64               `json:"duration_seconds,omitempty"`
+	Throughput float64               `json:"throughput_per_second,omitempty"`
+	Skipped    int                   `json:"skipped,omitempty"`
+	Types      map[string]*TypeStats `json:"types"`
+	Queue      *QueueReport          `json:"queue,omitempty"`
+	Database   *DatabaseReport       `json:"database,omitempty"`
+	Errors     []string              `json:"errors"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		Types: make(map[string]*TypeStats),
+	}
+}
+
+func (r *Report) stats(messageType string) *TypeStats {
+	stats, ok := r.Types[messageType]
+
+	if !ok {
+		stats = new(TypeStats)
+		r.Types[messageType] = stats
+	}
+
+	return stats
+}
+
+func (r *Report) Record(deviceId string, messageType string, err error) {
+	r.RecordLatency(deviceId, messageType, 0, err)
+}
+
+// RecordLatency records a request, the latency of successful requests is kept for
+// the percentiles of the report.
+func (r *Report) RecordLatency(deviceId string, messageType string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats(messageType)
+
+	stats.Sent++
+
+	switch {
+	case err == nil:
+		stats.Ok++
+
+		if latency > 0 && len(stats.latencies) < maxLatencySamples {
+			stats.latencies = append(stats.latencies, latency)
+		}
+		return
+	case err == ErrResponseTimeout:
+		stats.Timeouts++
+	default:
+		stats.Failed++
+	}
+
+	if len(r.Errors) < maxReportedErrors {
+		r.Errors = append(r.Errors, fmt.Sprintf("%v %v: %v", deviceId, messageType, err))
+	}
+}
+
+func (r *Report) Skip() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Skipped++
+}
+
+func (r *Report) Failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failures := 0
+
+	for _, stats := range r.Types {
+		failures += stats.Failed + stats.Timeouts
+	}
+
+	return failures
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index]
+}
+
+// summarize computes the error rates, throughput and latency percentiles, it must
+// be called with the lock held.
+func (r *Report) summarize() {
+	total := 0
+
+	for _, stats := range r.Types {
+		total += stats.Sent
+
+		if stats.Sent > 0 {
+			stats.ErrorRate = float64(stats.Failed+stats.Timeouts) / float64(stats.Sent)
+		}
+
+		if len(stats.latencies) == 0 {
+			continue
+		}
+
+		sort.Slice(stats.latencies, func(i, j int) bool {
+			return stats.latencies[i] < stats.latencies[j]
+		})
+
+		stats.Latency = &LatencyReport{
+			P50: milliseconds(percentile(stats.latencies, 0.50)),
+			P90: milliseconds(percentile(stats.latencies, 0.90)),
+			P99: milliseconds(percentile(stats.latencies, 0.99)),
+			Max: milliseconds(stats.latencies[len(stats.latencies)-1]),
+		}
+	}
+
+	if r.Duration > 0 {
+		r.Throughput = float64(total) / r.Duration
+	}
+}
+
+func (r *Report) WriteJson(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summarize()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+func (r *Report) WriteText(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summarize()
+
+	types := make([]string, 0, len(r.Types))
+
+	for messageType := range r.Types {
+		types = append(types, messageType)
+	}
+
+	sort.Strings(types)
+
+	fmt.Fprintf(w, "devices: %v\n", r.Devices)
+
+	if r.Duration > 0 {
+		fmt.Fprintf(w, "duration: %.1fs, throughput: %.1f req/s, skipped: %v\n", r.Duration, r.Throughput, r.Skipped)
+	}
+
+	fmt.Fprintf(w, "%-20s %8s %8s %8s %8s %8s %9s %9s %9s %9s\n", "type", "sent", "ok", "failed", "timeout", "err%", "p50 ms", "p90 ms", "p99 ms", "max ms")
+
+	for _, messageType := range types {
+		stats := r.Types[messageType]
+
+		fmt.Fprintf(w, "%-20s %8d %8d %8d %8d %8.2f", messageType, stats.Sent, stats.Ok, stats.Failed, stats.Timeouts, stats.ErrorRate*100)
+
+		if stats.Latency != nil {
+			fmt.Fprintf(w, " %9.1f %9.1f %9.1f %9.1f", stats.Latency.P50, stats.Latency.P90, stats.Latency.P99, stats.Latency.Max)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	if r.Queue != nil {
+		fmt.Fprintf(w, "queue: capacity %v, max length %v, max saturation %.0f%%, full events %v\n", r.Queue.Capacity, r.Queue.MaxLength, r.Queue.MaxSaturation*100, r.Queue.FullEvents)
+	}
+
+	if r.Database != nil {
+		fmt.Fprintf(w, "database: %v round trips for %v messages, %.2f per message\n", r.Database.RoundTrips, r.Database.Messages, r.Database.RoundTripsPerMessage)
+	}
+
+	for _, message := range r.Errors {
+		fmt.Fprintln(w, "error:", message)
+	}
+}
@@ -0,0 +1,266 @@
+package simulator
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// maxSyncRounds stops a sync loop whose acks never empty the queue.
+const maxSyncRounds = 10000
+
+// Scenario is the session every virtual device plays: connection, delete and
+// insert sync until both queues are empty, attendance scans and disconnection.
+type Scenario struct {
+	Students     []uint16
+	Scans        int
+	ScanInterval time.Duration
+	ChunkSize    uint16
+}
+
+// Run plays the scenario on every device concurrently and returns the report
+// once all devices are done.
+func Run(options *Options, deviceIds []string, scenario *Scenario) *Report {
+	report := NewReport()
+	report.Devices = len(deviceIds)
+
+	var wg sync.WaitGroup
+
+	for _, deviceId := range deviceIds {
+		wg.Add(1)
+
+		go func(device *Device) {
+			defer wg.Done()
+
+			if err := device.Connect(); err != nil {
+				report.Record(device.Id, "mqtt", err)
+				return
+			}
+
+			defer device.Close()
+
+			device.Play(scenario, report)
+		}(NewDevice(deviceId, options))
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+func (d *Device) Play(scenario *Scenario, report *Report) {
+	latency, err := d.connection()
+
+	report.RecordLatency(d.Id, "connection", latency, err)
+
+	if err != nil {
+		return
+	}
+
+	d.deleteSync(report)
+
+	d.insertSync(scenario.ChunkSize, report)
+
+	for i := 0; i < scenario.Scans && len(scenario.Students) > 0; i++ {
+		if i > 0 {
+			time.Sleep(scenario.ScanInterval)
+		}
+
+		studentId := scenario.Students[rand.Intn(len(scenario.Students))]
+
+		latency, err := d.Attendance(studentId)
+
+		report.RecordLatency(d.Id, "attendance", latency, err)
+	}
+
+	report.Record(d.Id, "disconnection", d.Send("disconnection", nil))
+}
+
+func (d *Device) connection() (time.Duration, error) {
+	request := models.ConnectionRequest{
+		Version: d.options.ProtocolVersion,
+	}
+
+	if d.options.Codec.Name() != "json" {
+		request.Encoding = d.options.Codec.Name()
+	}
+
+	response := new(models.ConnectionUpdateResponse)
+
+	latency, err := d.Request("connection", request, 1, response)
+
+	if err != nil {
+		return latency, err
+	}
+
+	if response.ErrorStatus != 0 {
+		return latency, fmt.Errorf("connection rejected with est %v ecd %v", response.ErrorStatus, response.ErrorCode)
+	}
+
+	return latency, nil
+}
+
+func (d *Device) deleteSync(report *Report) {
+	for round := 0; round < maxSyncRounds; round++ {
+		response := new(models.DeleteSyncResponse)
+
+		latency, err := d.Request("deletesync", nil, 2, response)
+
+		if err == nil && response.ErrorStatus != 0 {
+			err = fmt.Errorf("delete sync failed with est %v ecd %v", response.ErrorStatus, response.ErrorCode)
+		}
+
+		report.RecordLatency(d.Id, "deletesync", latency, err)
+
+		if err != nil || response.StudentsEmpty == 1 {
+			return
+		}
+
+		ack := new(models.DeleteSyncAckResponse)
+
+		latency, err = d.Request("deletesyncack", models.DeleteSyncAckRequest{StudentId: response.StudentId}, 3, ack)
+
+		if err == nil && ack.ErrorStatus != 0 {
+			err = fmt.Errorf("delete sync ack failed with est %v ecd %v", ack.ErrorStatus, ack.ErrorCode)
+		}
+
+		report.RecordLatency(d.Id, "deletesyncack", latency, err)
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (d *Device) insertSync(chunkSize uint16, report *Report) {
+	for round := 0; round < maxSyncRounds; round++ {
+		response := new(models.InsertSyncResponse)
+
+		var request any
+
+		if chunkSize > 0 {
+			request = models.InsertSyncRequest{MaxChunkSize: chunkSize}
+		}
+
+		latency, err := d.Request("insertsync", request, 4, response)
+
+		switch {
+		case err != nil:
+		case response.ErrorStatus != 0:
+			err = fmt.Errorf("insert sync failed with est %v ecd %v", response.ErrorStatus, response.ErrorCode)
+		case response.StudentsEmpty == 0 && response.ChunkSize == 0 && response.FingerPrintData == "":
+			err = fmt.Errorf("insert sync of student %v without a template", response.StudentId)
+		}
+
+		report.RecordLatency(d.Id, "insertsync", latency, err)
+
+		if err != nil || response.StudentsEmpty == 1 {
+			return
+		}
+
+		if response.ChunkSize > 0 {
+			err = d.receiveChunks(response)
+
+			report.Record(d.Id, "insertsyncchunkack", err)
+
+			if err != nil {
+				return
+			}
+		}
+
+		ack := new(models.InsertSyncAckResponse)
+
+		latency, err = d.Request("insertsyncack", models.InsertSyncAckRequest{StudentId: response.StudentId}, 5, ack)
+
+		if err == nil && ack.ErrorStatus != 0 {
+			err = fmt.Errorf("insert sync ack failed with est %v ecd %v", ack.ErrorStatus, ack.ErrorCode)
+		}
+
+		report.RecordLatency(d.Id, "insertsyncack", latency, err)
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receiveChunks acknowledges every chunk of a chunked insert and checks the
+// reassembled template against the announced length and checksum. A transfer
+// resumed from an earlier run can only be checked for its length.
+func (d *Device) receiveChunks(announced *models.InsertSyncResponse) error {
+	var data []byte
+
+	for sequence := announced.NextChunk; sequence < announced.TotalChunks; sequence++ {
+		chunk := new(models.InsertSyncChunk)
+
+		if err := d.Receive(8, chunk); err != nil {
+			return err
+		}
+
+		if chunk.ErrorStatus != 0 || chunk.StudentId != announced.StudentId || chunk.Sequence != sequence {
+			return fmt.Errorf("unexpected chunk %v of student %v with est %v, expected chunk %v", chunk.Sequence, chunk.StudentId, chunk.ErrorStatus, sequence)
+		}
+
+		data = append(data, chunk.Data...)
+
+		if err := d.Send("insertsyncchunkack", models.InsertSyncChunkAckRequest{StudentId: chunk.StudentId, Sequence: chunk.Sequence}); err != nil {
+			return err
+		}
+	}
+
+	expectedLength := int(announced.TemplateLength) - int(announced.NextChunk)*int(announced.ChunkSize)
+
+	if len(data) != expectedLength {
+		return fmt.Errorf("received %v template bytes, expected %v", len(data), expectedLength)
+	}
+
+	if announced.NextChunk == 0 && crc32.ChecksumIEEE(data) != announced.Checksum {
+		return fmt.Errorf("template checksum mismatch for student %v", announced.StudentId)
+	}
+
+	return nil
+}
+
+// Attendance sends a scan of the student with the next index and the current time
+// of the device clock, and checks that the response acknowledges that index.
+func (d *Device) Attendance(studentId uint16) (time.Duration, error) {
+	d.index++
+
+	now := time.Now()
+
+	var request any = models.UpdateAttendanceRequest{
+		StudentUnitId: studentId,
+		Index:         d.index,
+		TimeStamp:     now.In(d.options.Location).Format("2006-01-02T15:04:05"),
+	}
+
+	if d.options.ProtocolVersion >= models.ProtocolV2 {
+		request = models.UpdateAttendanceRequestV2{
+			StudentUnitId: studentId,
+			Index:         d.index,
+			ScannedAt:     now.Unix(),
+		}
+	}
+
+	response := new(models.UpdateAttendanceResponse)
+
+	latency, err := d.Request("attendance", request, 6, response)
+
+	if err != nil {
+		return latency, err
+	}
+
+	if response.ErrorStatus != 0 {
+		return latency, fmt.Errorf("attendance of student %v failed with est %v ecd %v", studentId, response.ErrorStatus, response.ErrorCode)
+	}
+
+	if response.Index != d.index {
+		return latency, fmt.Errorf("attendance acknowledged index %v, expected %v", response.Index, d.index)
+	}
+
+	return latency, nil
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type jsonlSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+func NewJSONLSink(writer io.Writer) *jsonlSink {
+	return &jsonlSink{
+		writer: writer,
+	}
+}
+
+func NewFileSink(path string) (*jsonlSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonlSink{
+		writer: file,
+		closer: file,
+	}, nil
+}
+
+func (s *jsonlSink) Publish(event *models.Event) error {
+	line, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.writer.Write(line)
+	return err
+}
+
+func (s *jsonlSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type kafkaRecord struct {
+	Key   string        `json:"key"`
+	Value *models.Event `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaRestSink produces through the Kafka REST proxy v2 API, which Confluent REST
+// Proxy and the Redpanda HTTP proxy both serve. Records are keyed by device id so
+// the events of a device stay ordered within a partition.
+type kafkaRestSink struct {
+	topicUrl   string
+	httpClient *http.Client
+}
+
+func NewKafkaRestSink(proxyUrl string, topic string) *kafkaRestSink {
+	if topic == "" {
+		topic = "biometric-events"
+	}
+
+	return &kafkaRestSink{
+		topicUrl: strings.TrimRight(proxyUrl, "/") + "/topics/" + url.PathEscape(topic),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func (s *kafkaRestSink) Publish(event *models.Event) error {
+	body, err := json.Marshal(kafkaRecords{
+		Records: []kafkaRecord{
+			{
+				Key:   event.DeviceId,
+				Value: event,
+			},
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.topicUrl, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	request.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	response, err := s.httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("kafka rest proxy returned %v: %s", response.StatusCode, bytes.TrimSpace(responseBody))
+	}
+
+	return nil
+}
+
+func (s *kafkaRestSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
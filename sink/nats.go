@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const natsTimeout = 5 * time.Second
+
+// natsSink publishes with the nats.go client, which reconnects on its own and
+// buffers the events published while it does. Publish flushes every event, errors
+// the server sends back later, such as a permissions violation, are logged and
+// counted in event_sink_errors.
+type natsSink struct {
+	conn          *nats.Conn
+	subjectPrefix string
+}
+
+func NewNatsSink(serverUrl string, subjectPrefix string) (*natsSink, error) {
+	if subjectPrefix == "" {
+		subjectPrefix = "biometric.events"
+	}
+
+	conn, err := nats.Connect(
+		serverUrl,
+		nats.Name("biometric-message-producer"),
+		nats.Timeout(natsTimeout),
+		nats.MaxReconnects(-1),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			metrics.EventSinkErrors.Add(1)
+			log.Println("nats event sink error: ", err.Error())
+		}),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsSink{
+		conn:          conn,
+		subjectPrefix: subjectPrefix,
+	}, nil
+}
+
+func (s *natsSink) Publish(event *models.Event) error {
+	payload, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.conn.Publish(s.subjectPrefix+"."+event.Type, payload); err != nil {
+		return err
+	}
+
+	return s.conn.FlushTimeout(natsTimeout)
+}
+
+// Close flushes the events still buffered before it closes the connection.
+func (s *natsSink) Close() error {
+	err := s.conn.FlushTimeout(natsTimeout)
+
+	s.conn.Close()
+
+	return err
+}
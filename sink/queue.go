@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"log"
+	"sync"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// queuedSink hands the events to a background goroutine so a slow or unreachable
+// broker never holds up the workers of the processor. Events that find the queue
+// full are dropped and counted in events_dropped.
+type queuedSink struct {
+	sink   models.EventSink
+	events chan *models.Event
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+func NewQueuedSink(sink models.EventSink, size int) *queuedSink {
+	q := &queuedSink{
+		sink:   sink,
+		events: make(chan *models.Event, size),
+		done:   make(chan struct{}),
+	}
+
+	metrics.PublishQueue("event_queue", func() metrics.QueueStats {
+		return metrics.QueueStats{
+			Length:   len(q.events),
+			Capacity: cap(q.events),
+		}
+	})
+
+	go q.drain()
+
+	return q
+}
+
+func (q *queuedSink) drain() {
+	defer close(q.done)
+
+	for event := range q.events {
+		if err := q.sink.Publish(event); err != nil {
+			metrics.EventSinkErrors.Add(1)
+			log.Println("failed to publish the event to the event sink, Event Type: ", event.Type, " Device Id: ", event.DeviceId, " Error: ", err.Error())
+		}
+	}
+}
+
+func (q *queuedSink) Publish(event *models.Event) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		metrics.EventsDropped.Add(1)
+		return nil
+	}
+
+	select {
+	case q.events <- event:
+	default:
+		metrics.EventsDropped.Add(1)
+	}
+
+	return nil
+}
+
+// Close publishes the events still queued before it closes the sink.
+func (q *queuedSink) Close() error {
+	q.mu.Lock()
+
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+
+	q.closed = true
+	close(q.events)
+	q.mu.Unlock()
+
+	<-q.done
+
+	return q.sink.Close()
+}
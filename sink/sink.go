@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+type discardSink struct{}
+
+func (discardSink) Publish(event *models.Event) error {
+	return nil
+}
+
+func (discardSink) Close() error {
+	return nil
+}
+
+// New builds the sink selected by EVENT_SINK. target is a file path for file,
+// a nats:// url for nats and the REST proxy base url for kafka. The events are
+// published from a queue of queueSize events.
+func New(kind string, target string, topic string, queueSize int) (models.EventSink, error) {
+	var sink models.EventSink
+	var err error
+
+	switch kind {
+	case "", "none":
+		return discardSink{}, nil
+	case "stdout":
+		sink = NewJSONLSink(os.Stdout)
+	case "file":
+		sink, err = NewFileSink(target)
+	case "nats":
+		sink, err = NewNatsSink(target, topic)
+	case "kafka":
+		sink = NewKafkaRestSink(target, topic)
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", kind)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewQueuedSink(sink, queueSize), nil
+}
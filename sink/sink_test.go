@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/metrics"
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// blockingSink holds every publish until release is closed.
+type blockingSink struct {
+	release   chan struct{}
+	published chan *models.Event
+}
+
+func (b *blockingSink) Publish(event *models.Event) error {
+	<-b.release
+	b.published <- event
+	return nil
+}
+
+func (b *blockingSink) Close() error {
+	return nil
+}
+
+func TestQueuedSinkDropsWhenFull(t *testing.T) {
+	inner := &blockingSink{release: make(chan struct{}), published: make(chan *models.Event, 10)}
+	queue := NewQueuedSink(inner, 2)
+
+	dropped := metrics.EventsDropped.Value()
+
+	start := time.Now()
+
+	//one event is held by the blocked publish, two fill the queue
+	for i := 0; i < 6; i++ {
+		if err := queue.Publish(&models.Event{Id: strings.Repeat("x", i+1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("publish waited %v for the blocked sink", elapsed)
+	}
+
+	close(inner.release)
+
+	if err := queue.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	published := len(inner.published)
+
+	if published < 2 || published > 3 {
+		t.Errorf("got %v published events, want the queued ones", published)
+	}
+
+	if got := metrics.EventsDropped.Value() - dropped; got != int64(6-published) {
+		t.Errorf("got %v dropped events, want %v", got, 6-published)
+	}
+}
+
+// fakeNatsServer greets one client, answers PING and answers every PUB with reply.
+// The subjects published to are passed to subjects.
+func fakeNatsServer(t *testing.T, reply string, subjects chan<- string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+
+		conn.Write([]byte(`INFO {"server_id":"fake","version":"2.10.0","proto":1,"max_payload":1048576}` + "\r\n"))
+
+		for {
+			line, err := reader.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "PING"):
+				conn.Write([]byte("PONG\r\n"))
+			case strings.HasPrefix(line, "PUB"):
+				//the payload line
+				reader.ReadString('\n')
+
+				subjects <- strings.Fields(line)[1]
+
+				conn.Write([]byte(reply))
+			}
+		}
+	}()
+
+	return "nats://" + listener.Addr().String()
+}
+
+func TestNatsSinkPublishes(t *testing.T) {
+	subjects := make(chan string, 10)
+
+	sink, err := NewNatsSink(fakeNatsServer(t, "", subjects), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := sink.Publish(&models.Event{Type: models.EventDeviceConnected}); err != nil {
+			t.Errorf("publish %v: %v", i, err)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if subject := <-subjects; subject != "biometric.events.device.connected" {
+			t.Errorf("got subject %q, want biometric.events.device.connected", subject)
+		}
+	}
+}
+
+func TestNatsSinkCountsServerErrors(t *testing.T) {
+	subjects := make(chan string, 10)
+
+	sink, err := NewNatsSink(fakeNatsServer(t, "-ERR 'Permissions Violation for Publish to biometric.events.device.connected'\r\n", subjects), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sink.Close()
+
+	errors := metrics.EventSinkErrors.Value()
+
+	if err := sink.Publish(&models.Event{Type: models.EventDeviceConnected}); err != nil {
+		t.Fatal(err)
+	}
+
+	//the error handler runs on a goroutine of the client
+	deadline := time.Now().Add(2 * time.Second)
+
+	for metrics.EventSinkErrors.Value() == errors && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := metrics.EventSinkErrors.Value() - errors; got != 1 {
+		t.Errorf("got %v sink errors, want the error of the server counted once", got)
+	}
+}
@@ -0,0 +1,221 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	placeholderTenant  = "{tenant}"
+	placeholderSite    = "{site}"
+	placeholderDevice  = "{device}"
+	placeholderType    = "{type}"
+	placeholderVersion = "{version}"
+)
+
+type Route struct {
+	Tenant   string
+	Site     string
+	DeviceId string
+	Type     string
+	Version  string
+	// Encoding is the payload encoding suffix of the message type, it is not part
+	// of the topic template and is set by the processor.
+	Encoding string
+}
+
+type Template struct {
+	pattern  string
+	segments []string
+}
+
+func NewTemplate(pattern string) (*Template, error) {
+	pattern = strings.TrimSpace(pattern)
+
+	if pattern == "" {
+		return nil, errors.New("empty topic template")
+	}
+
+	segments := strings.Split(pattern, "/")
+
+	seen := make(map[string]bool)
+
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, "+#") {
+			return nil, fmt.Errorf("topic template %q must not contain mqtt wildcards", pattern)
+		}
+
+		if !strings.ContainsAny(segment, "{}") {
+			continue
+		}
+
+		switch segment {
+		case placeholderTenant, placeholderSite, placeholderDevice, placeholderType, placeholderVersion:
+		default:
+			return nil, fmt.Errorf("invalid placeholder segment %q in topic template %q", segment, pattern)
+		}
+
+		if seen[segment] {
+			return nil, fmt.Errorf("duplicate placeholder %v in topic template %q", segment, pattern)
+		}
+
+		seen[segment] = true
+	}
+
+	if !seen[placeholderDevice] {
+		return nil, fmt.Errorf("topic template %q must contain %v", pattern, placeholderDevice)
+	}
+
+	return &Template{
+		pattern:  pattern,
+		segments: segments,
+	}, nil
+}
+
+func (t *Template) String() string {
+	return t.pattern
+}
+
+func (t *Template) Has(placeholder string) bool {
+	for _, segment := range t.segments {
+		if segment == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) HasType() bool {
+	return t.Has(placeholderType)
+}
+
+// Fills checks that every placeholder of other is one of t, so that a route parsed
+// with t can always be formatted with other.
+func (t *Template) Fills(other *Template) error {
+	for _, segment := range other.segments {
+		if isPlaceholder(segment) && !t.Has(segment) {
+			return fmt.Errorf("%v of topic template %q is not in topic template %q", segment, other.pattern, t.pattern)
+		}
+	}
+
+	return nil
+}
+
+// Subscription replaces every placeholder with the single level wildcard.
+func (t *Template) Subscription() string {
+	segments := make([]string, len(t.segments))
+
+	for i, segment := range t.segments {
+		if isPlaceholder(segment) {
+			segments[i] = "+"
+		} else {
+			segments[i] = segment
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (t *Template) Parse(topic string) (Route, bool) {
+	var route Route
+
+	segments := strings.Split(topic, "/")
+
+	if len(segments) != len(t.segments) {
+		return route, false
+	}
+
+	for i, segment := range t.segments {
+		value := strings.TrimSpace(segments[i])
+
+		switch segment {
+		case placeholderTenant:
+			route.Tenant = value
+		case placeholderSite:
+			route.Site = value
+		case placeholderDevice:
+			route.DeviceId = value
+		case placeholderType:
+			route.Type = value
+		case placeholderVersion:
+			route.Version = value
+		default:
+			if segment != segments[i] {
+				return route, false
+			}
+			continue
+		}
+
+		if value == "" {
+			return route, false
+		}
+	}
+
+	return route, true
+}
+
+// DeviceId extracts the device of a topic that does not match the template, as long
+// as the segments up to the device placeholder do.
+func (t *Template) DeviceId(topic string) (string, bool) {
+	segments := strings.Split(topic, "/")
+
+	for i, segment := range t.segments {
+		if i >= len(segments) {
+			return "", false
+		}
+
+		value := strings.TrimSpace(segments[i])
+
+		switch segment {
+		case placeholderDevice:
+			return value, value != "" && !strings.ContainsAny(value, "+#")
+		case placeholderTenant, placeholderSite, placeholderType, placeholderVersion:
+			if value == "" {
+				return "", false
+			}
+		default:
+			if segment != segments[i] {
+				return "", false
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (t *Template) Format(route Route) (string, error) {
+	segments := make([]string, len(t.segments))
+
+	for i, segment := range t.segments {
+		var value string
+
+		switch segment {
+		case placeholderTenant:
+			value = route.Tenant
+		case placeholderSite:
+			value = route.Site
+		case placeholderDevice:
+			value = route.DeviceId
+		case placeholderType:
+			value = route.Type
+		case placeholderVersion:
+			value = route.Version
+		default:
+			segments[i] = segment
+			continue
+		}
+
+		if value == "" {
+			return "", fmt.Errorf("missing value for %v in topic template %q", segment, t.pattern)
+		}
+
+		segments[i] = value
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+func isPlaceholder(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
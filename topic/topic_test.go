@@ -0,0 +1,155 @@
+package topic
+
+import (
+	"testing"
+)
+
+func TestNewTemplate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"{device}/process/{type}/message", false},
+		{"{tenant}/{site}/{device}/{version}/{type}", false},
+		{" {device} ", false},
+		{"", true},
+		{"process/{type}/message", true},
+		{"{device}/{unit}", true},
+		{"{device}/{Type}", true},
+		{"{device}/pre{type}", true},
+		{"{device}/{type}/{type}", true},
+		{"{device}/+/message", true},
+		{"{device}/#", true},
+	}
+
+	for _, test := range tests {
+		if _, err := NewTemplate(test.pattern); (err != nil) != test.wantErr {
+			t.Errorf("NewTemplate(%q): got error %v, want error %v", test.pattern, err, test.wantErr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	template, err := NewTemplate("{tenant}/{site}/{device}/process/{type}/{version}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic string
+		want  Route
+		ok    bool
+	}{
+		{"acme/north/vs23cg003/process/attendance/v2", Route{Tenant: "acme", Site: "north", DeviceId: "vs23cg003", Type: "attendance", Version: "v2"}, true},
+		{"acme/north/vs23cg003/process/attendance.cbor/2", Route{Tenant: "acme", Site: "north", DeviceId: "vs23cg003", Type: "attendance.cbor", Version: "2"}, true},
+		{"acme/north/vs23cg003/process/attendance/v2/extra", Route{}, false},
+		{"acme/north/vs23cg003/process/attendance", Route{}, false},
+		{"acme/north/vs23cg003/message/attendance/v2", Route{}, false},
+		{"acme//vs23cg003/process/attendance/v2", Route{}, false},
+		{"acme/north/ /process/attendance/v2", Route{}, false},
+	}
+
+	for _, test := range tests {
+		route, ok := template.Parse(test.topic)
+
+		if ok != test.ok {
+			t.Errorf("Parse(%q): got ok %v, want %v", test.topic, ok, test.ok)
+			continue
+		}
+
+		if ok && route != test.want {
+			t.Errorf("Parse(%q): got %+v, want %+v", test.topic, route, test.want)
+		}
+	}
+}
+
+func TestDeviceId(t *testing.T) {
+	template, err := NewTemplate("{tenant}/{device}/process/{type}/message")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic string
+		want  string
+		ok    bool
+	}{
+		{"acme/vs23cg003/process/attendance/message", "vs23cg003", true},
+		{"acme/vs23cg003/process/attendance/message/extra", "vs23cg003", true},
+		{"acme/vs23cg003", "vs23cg003", true},
+		{"acme", "", false},
+		{"/vs23cg003/process", "", false},
+		{"acme/vs23+/process", "vs23+", false},
+	}
+
+	for _, test := range tests {
+		deviceId, ok := template.DeviceId(test.topic)
+
+		if ok != test.ok || deviceId != test.want {
+			t.Errorf("DeviceId(%q): got %q %v, want %q %v", test.topic, deviceId, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		pattern string
+		route   Route
+		want    string
+		wantErr bool
+	}{
+		{"{device}", Route{DeviceId: "vs23cg003"}, "vs23cg003", false},
+		{"{tenant}/{site}/{device}/response/{version}", Route{Tenant: "acme", Site: "north", DeviceId: "vs23cg003", Version: "v2"}, "acme/north/vs23cg003/response/v2", false},
+		{"{tenant}/{device}", Route{DeviceId: "vs23cg003"}, "", true},
+		{"{device}/{version}", Route{DeviceId: "vs23cg003"}, "", true},
+	}
+
+	for _, test := range tests {
+		template, err := NewTemplate(test.pattern)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := template.Format(test.route)
+
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("Format(%q, %+v): got %q %v, want %q and error %v", test.pattern, test.route, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestFills(t *testing.T) {
+	tests := []struct {
+		request  string
+		response string
+		wantErr  bool
+	}{
+		{"{device}/process/{type}/message", "{device}", false},
+		{"{tenant}/{site}/{device}/{type}", "{tenant}/{site}/{device}/response", false},
+		{"{tenant}/{device}/{type}/{version}", "{device}/{version}", false},
+		{"{device}/process/{type}/message", "{tenant}/{device}", true},
+		{"{tenant}/{device}/{type}", "{tenant}/{site}/{device}", true},
+		{"{device}/process/{type}/message", "{device}/{version}", true},
+	}
+
+	for _, test := range tests {
+		request, err := NewTemplate(test.request)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := NewTemplate(test.response)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := request.Fills(response); (err != nil) != test.wantErr {
+			t.Errorf("%q fills %q: got error %v, want error %v", test.request, test.response, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+const (
+	claimBatchSize = 50
+	leaseMargin    = time.Minute
+	pollInterval   = time.Second
+	minBackoff     = 5 * time.Second
+	maxBackoff     = time.Hour
+	pruneInterval  = time.Hour
+	pruneBatchSize = 1000
+)
+
+type envelope struct {
+	Id        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type dispatcher struct {
+	dbRepo      models.WebhookDatabaseInterface
+	httpClient  *http.Client
+	maxAttempts int
+	lease       time.Duration
+	retention   time.Duration
+}
+
+// NewDispatcher delivers the outbox events to the webhook endpoints. Events whose
+// deliveries all succeeded are deleted after retention, zero keeps them forever.
+func NewDispatcher(dbRepo models.WebhookDatabaseInterface, timeout time.Duration, maxAttempts int, retention time.Duration) *dispatcher {
+	return &dispatcher{
+		dbRepo: dbRepo,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		maxAttempts: maxAttempts,
+		//the claimed rows are delivered one after another, the lease outlives a batch that times out on every row
+		lease:     claimBatchSize*timeout + leaseMargin,
+		retention: retention,
+	}
+}
+
+func (d *dispatcher) Start() {
+	go func() {
+		for {
+			deliveries, err := d.dbRepo.ClaimWebhookDeliveries(claimBatchSize, d.lease)
+
+			if err != nil {
+				log.Println("error occurred with database while claiming webhook deliveries, Error: ", err.Error())
+			}
+
+			for _, delivery := range deliveries {
+				d.deliver(delivery)
+			}
+
+			if len(deliveries) < claimBatchSize {
+				time.Sleep(pollInterval)
+			}
+		}
+	}()
+
+	if d.retention > 0 {
+		go func() {
+			for {
+				d.prune()
+				time.Sleep(pruneInterval)
+			}
+		}()
+	}
+}
+
+// prune deletes the delivered events past the retention in batches, so that a large
+// backlog does not hold one long delete.
+func (d *dispatcher) prune() int64 {
+	var pruned int64
+
+	for {
+		deleted, err := d.dbRepo.PruneDeliveredWebhookEvents(d.retention, pruneBatchSize)
+
+		if err != nil {
+			log.Println("error occurred with database while pruning delivered webhook events, Error: ", err.Error())
+			return pruned
+		}
+
+		pruned += deleted
+
+		if deleted < pruneBatchSize {
+			return pruned
+		}
+	}
+}
+
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *dispatcher) deliver(delivery models.WebhookDelivery) {
+	body, _ := json.Marshal(envelope{
+		Id:        delivery.EventId,
+		Type:      delivery.EventType,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Payload,
+	})
+
+	statusCode, err := d.post(delivery, body)
+
+	if err == nil {
+		if err := d.dbRepo.MarkWebhookDelivered(delivery.EventId, delivery.EndpointId, statusCode); err != nil {
+			log.Println("error occurred with database while marking the webhook delivered, Event Id: ", delivery.EventId, " Error: ", err.Error())
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+
+	var nextAttemptAt *time.Time
+
+	if attempts < d.maxAttempts {
+		next := time.Now().Add(backoff(attempts))
+		nextAttemptAt = &next
+	}
+
+	log.Println("webhook delivery failed, Event Id: ", delivery.EventId, " Url: ", delivery.Url, " Attempt: ", attempts, " Error: ", err.Error())
+
+	if err := d.dbRepo.MarkWebhookFailed(delivery.EventId, delivery.EndpointId, statusCode, err.Error(), nextAttemptAt); err != nil {
+		log.Println("error occurred with database while marking the webhook failed, Event Id: ", delivery.EventId, " Error: ", err.Error())
+	}
+}
+
+func (d *dispatcher) post(delivery models.WebhookDelivery, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.Url, bytes.NewReader(body))
+
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.EventId, 10))
+	request.Header.Set("X-Webhook-Event", delivery.EventType)
+	request.Header.Set("X-Webhook-Timestamp", timestamp)
+	request.Header.Set("X-Webhook-Signature", Sign(delivery.Secret, timestamp, body))
+
+	response, err := d.httpClient.Do(request)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status code %v", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
+
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vithsutra/biometric-project-message-processor/models"
+)
+
+// deliveryResults keeps what the dispatcher reported back to the database.
+type deliveryResults struct {
+	mu            sync.Mutex
+	delivered     []int
+	failed        []int
+	nextAttemptAt []*time.Time
+	prunable      int64
+	pruneCalls    []time.Duration
+}
+
+func (r *deliveryResults) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (r *deliveryResults) MarkWebhookDelivered(eventId int64, endpointId int64, statusCode int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.delivered = append(r.delivered, statusCode)
+	return nil
+}
+
+func (r *deliveryResults) MarkWebhookFailed(eventId int64, endpointId int64, statusCode int, reason string, nextAttemptAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failed = append(r.failed, statusCode)
+	r.nextAttemptAt = append(r.nextAttemptAt, nextAttemptAt)
+	return nil
+}
+
+func (r *deliveryResults) PruneDeliveredWebhookEvents(olderThan time.Duration, limit int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := min(r.prunable, int64(limit))
+
+	r.prunable -= deleted
+	r.pruneCalls = append(r.pruneCalls, olderThan)
+	return deleted, nil
+}
+
+func testDelivery(url string, attempts int) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		EventId:    42,
+		EndpointId: 7,
+		Url:        url,
+		Secret:     "endpoint-secret",
+		EventType:  models.EventAttendanceLogin,
+		Payload:    []byte(`{"student_id":"student-7"}`),
+		CreatedAt:  time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC),
+		Attempts:   attempts,
+	}
+}
+
+func TestDeliverSignsTheBody(t *testing.T) {
+	var header http.Header
+	var body []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	results := &deliveryResults{}
+
+	NewDispatcher(results, time.Second, 3, 0).deliver(testDelivery(server.URL, 0))
+
+	if got, want := header.Get("X-Webhook-Signature"), Sign("endpoint-secret", header.Get("X-Webhook-Timestamp"), body); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+
+	if got := header.Get("X-Webhook-Event"); got != models.EventAttendanceLogin {
+		t.Errorf("got event %q, want %q", got, models.EventAttendanceLogin)
+	}
+
+	if got := header.Get("X-Webhook-Id"); got != "42" {
+		t.Errorf("got id %q, want 42", got)
+	}
+
+	var sent envelope
+
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent.Id != 42 || sent.Type != models.EventAttendanceLogin || string(sent.Data) != `{"student_id":"student-7"}` {
+		t.Errorf("got envelope %+v", sent)
+	}
+
+	if len(results.delivered) != 1 || results.delivered[0] != http.StatusNoContent || len(results.failed) != 0 {
+		t.Errorf("got delivered %v failed %v, want one delivery with status 204", results.delivered, results.failed)
+	}
+}
+
+func TestSignDependsOnSecretTimestampAndBody(t *testing.T) {
+	signature := Sign("secret", "1717405200", []byte(`{}`))
+
+	for _, other := range []string{
+		Sign("other", "1717405200", []byte(`{}`)),
+		Sign("secret", "1717405201", []byte(`{}`)),
+		Sign("secret", "1717405200", []byte(`{ }`)),
+	} {
+		if other == signature {
+			t.Errorf("different input signed as %q", signature)
+		}
+	}
+}
+
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	results := &deliveryResults{}
+	dispatcher := NewDispatcher(results, time.Second, 3, 0)
+
+	for attempts := 0; attempts < 3; attempts++ {
+		before := time.Now()
+
+		dispatcher.deliver(testDelivery(server.URL, attempts))
+
+		nextAttemptAt := results.nextAttemptAt[attempts]
+
+		if results.failed[attempts] != http.StatusInternalServerError {
+			t.Errorf("attempt %v: got status %v, want 500", attempts+1, results.failed[attempts])
+		}
+
+		//the last attempt gives up
+		if attempts+1 == 3 {
+			if nextAttemptAt != nil {
+				t.Errorf("attempt %v: retried at %v after the last attempt", attempts+1, nextAttemptAt)
+			}
+			continue
+		}
+
+		if nextAttemptAt == nil {
+			t.Fatalf("attempt %v: not retried", attempts+1)
+		}
+
+		delay := backoff(attempts + 1)
+
+		if nextAttemptAt.Before(before.Add(delay)) || nextAttemptAt.After(time.Now().Add(delay)) {
+			t.Errorf("attempt %v: retried at %v, want %v after the attempt", attempts+1, nextAttemptAt, delay)
+		}
+	}
+
+	if len(results.delivered) != 0 {
+		t.Errorf("got %v deliveries, want none", len(results.delivered))
+	}
+}
+
+func TestDeliverRetriesUnreachableEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	results := &deliveryResults{}
+
+	NewDispatcher(results, time.Second, 3, 0).deliver(testDelivery(url, 0))
+
+	if len(results.failed) != 1 || results.failed[0] != 0 || results.nextAttemptAt[0] == nil {
+		t.Errorf("got failed %v next attempts %v, want a retry without a status", results.failed, results.nextAttemptAt)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{10, 2560 * time.Second},
+		{11, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, test := range tests {
+		if got := backoff(test.attempts); got != test.want {
+			t.Errorf("backoff(%v): got %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestLeaseOutlivesBatch(t *testing.T) {
+	timeout := 10 * time.Second
+
+	if lease := NewDispatcher(&deliveryResults{}, timeout, 3, 0).lease; lease <= claimBatchSize*timeout {
+		t.Errorf("got lease %v, shorter than a batch of %v timed out deliveries", lease, claimBatchSize)
+	}
+}
+
+func TestPruneDeletesInBatches(t *testing.T) {
+	results := &deliveryResults{prunable: 2*pruneBatchSize + 10}
+
+	if pruned := NewDispatcher(results, time.Second, 3, 48*time.Hour).prune(); pruned != 2*pruneBatchSize+10 {
+		t.Errorf("pruned %v events, want all %v", pruned, 2*pruneBatchSize+10)
+	}
+
+	//two full batches and the rest
+	if len(results.pruneCalls) != 3 {
+		t.Errorf("got %v prune calls, want 3", len(results.pruneCalls))
+	}
+
+	for _, olderThan := range results.pruneCalls {
+		if olderThan != 48*time.Hour {
+			t.Errorf("pruned events older than %v, want the retention of 48h", olderThan)
+		}
+	}
+}